@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ArgumentType represents the kind of value a command argument accepts.
+type ArgumentType int8
+
+const (
+	// ArgumentUser is a user mention or a raw user ID.
+	ArgumentUser ArgumentType = iota
+	// ArgumentChannel is a channel mention or a raw channel ID.
+	ArgumentChannel ArgumentType = iota
+	// ArgumentRole is a role mention or a raw role ID.
+	ArgumentRole ArgumentType = iota
+	// ArgumentInteger is a whole number.
+	ArgumentInteger ArgumentType = iota
+	// ArgumentDuration is a Go duration string such as "1h30m".
+	ArgumentDuration ArgumentType = iota
+	// ArgumentURL is an absolute http or https URL.
+	ArgumentURL ArgumentType = iota
+	// ArgumentString is a single word or a "quoted string".
+	ArgumentString ArgumentType = iota
+	// ArgumentRest is everything that remains on the line, it must be the last
+	// argument of a command.
+	ArgumentRest ArgumentType = iota
+)
+
+var (
+	// MatchUserMention matches a Discord user mention and captures the ID
+	MatchUserMention = regexp.MustCompile(`^<@!?([0-9]+)>$`)
+	// MatchChannelMention matches a Discord channel mention and captures the ID
+	MatchChannelMention = regexp.MustCompile(`^<#([0-9]+)>$`)
+	// MatchRoleMention matches a Discord role mention and captures the ID
+	MatchRoleMention = regexp.MustCompile(`^<@&([0-9]+)>$`)
+	// MatchSnowflake matches a raw Discord ID
+	MatchSnowflake = regexp.MustCompile(`^[0-9]+$`)
+)
+
+func (t ArgumentType) String() string {
+	switch t {
+	case ArgumentUser:
+		return "user mention"
+	case ArgumentChannel:
+		return "channel mention"
+	case ArgumentRole:
+		return "role mention"
+	case ArgumentInteger:
+		return "whole number"
+	case ArgumentDuration:
+		return "duration such as 1h30m"
+	case ArgumentURL:
+		return "URL"
+	case ArgumentString:
+		return "word or \"quoted text\""
+	case ArgumentRest:
+		return "line of text"
+	}
+	return "value"
+}
+
+// Argument describes a single parameter of a command, Prompt is the question
+// asked for the argument when a contextual command is used without it and
+// Suggest, if set, provides slash command autocomplete values
+type Argument struct {
+	Name     string
+	Type     ArgumentType
+	Optional bool
+	Prompt   string
+	Suggest  func(partial string) []string
+}
+
+// Arguments stores the parsed values of a command's arguments by name, optional
+// arguments that were not given are absent.
+type Arguments map[string]interface{}
+
+// Has returns true if the named argument was given
+func (a Arguments) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// String returns a string, user, channel, role or rest argument
+func (a Arguments) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns an integer argument
+func (a Arguments) Int(name string) int {
+	i, _ := a[name].(int)
+	return i
+}
+
+// Duration returns a duration argument
+func (a Arguments) Duration(name string) time.Duration {
+	d, _ := a[name].(time.Duration)
+	return d
+}
+
+// URL returns a URL argument
+func (a Arguments) URL(name string) *url.URL {
+	u, _ := a[name].(*url.URL)
+	return u
+}
+
+// ArgumentError is returned when a command's arguments do not match its schema
+type ArgumentError struct {
+	Argument *Argument // the argument at fault, nil when there are too many
+	Position int       // index of the argument at fault in the schema
+	Value    string    // the value that was given, if any
+}
+
+// Missing returns true if the error is due to a required argument being absent
+func (e ArgumentError) Missing() bool {
+	return e.Argument != nil && e.Value == ""
+}
+
+func (e ArgumentError) Error() string {
+	if e.Argument == nil {
+		return fmt.Sprintf("unexpected `%s`", e.Value)
+	}
+	if e.Value == "" {
+		return fmt.Sprintf("missing %s, expected a %s", e.Argument.Name, e.Argument.Type)
+	}
+	return fmt.Sprintf("`%s` is not a valid %s, expected a %s", e.Value, e.Argument.Name, e.Argument.Type)
+}
+
+// Usage renders the command's argument schema, such as `whois <user>`
+func (command Command) Usage(trigger string) string {
+	buf := bytes.NewBufferString(trigger)
+	for _, arg := range command.Arguments {
+		name := arg.Name
+		if arg.Type == ArgumentRest {
+			name += "..."
+		}
+		if arg.Optional {
+			buf.WriteString(fmt.Sprintf(" [%s]", name))
+		} else {
+			buf.WriteString(fmt.Sprintf(" <%s>", name))
+		}
+	}
+	return buf.String()
+}
+
+// Help renders the usage, description and example of a command
+func (command Command) Help(prefix, trigger string) string {
+	help := fmt.Sprintf("Usage: `%s`\n%s", command.Usage(prefix+trigger), command.Description)
+	if command.Example != "" {
+		help += "\nExample: " + prefix + command.Example
+	}
+	return help
+}
+
+// ParseArguments parses the raw text following a command trigger against the
+// command's argument schema, the arguments parsed before any error are returned
+// alongside it
+func (command Command) ParseArguments(raw string) (args Arguments, err error) {
+	args = make(Arguments)
+	tokens := tokenise(raw)
+
+	for i := range command.Arguments {
+		arg := &command.Arguments[i]
+
+		if len(tokens) == 0 {
+			if arg.Optional {
+				continue
+			}
+			return args, ArgumentError{Argument: arg, Position: i}
+		}
+
+		if arg.Type == ArgumentRest {
+			args[arg.Name] = trimQuotes(strings.TrimSpace(raw[tokens[0].offset:]))
+			return args, nil
+		}
+
+		value, ok := parseValue(arg.Type, tokens[0].value)
+		if !ok {
+			return args, ArgumentError{Argument: arg, Position: i, Value: tokens[0].value}
+		}
+		args[arg.Name] = value
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) > 0 {
+		return args, ArgumentError{Position: len(command.Arguments), Value: tokens[0].value}
+	}
+
+	return args, nil
+}
+
+func parseValue(t ArgumentType, value string) (interface{}, bool) {
+	switch t {
+	case ArgumentUser:
+		return parseID(MatchUserMention, value)
+	case ArgumentChannel:
+		return parseID(MatchChannelMention, value)
+	case ArgumentRole:
+		return parseID(MatchRoleMention, value)
+	case ArgumentInteger:
+		i, err := strconv.Atoi(value)
+		return i, err == nil
+	case ArgumentDuration:
+		d, err := time.ParseDuration(value)
+		return d, err == nil
+	case ArgumentURL:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, false
+		}
+		return u, true
+	case ArgumentString:
+		return value, value != ""
+	}
+	return nil, false
+}
+
+// parseID accepts either a mention matching `mention` or a raw ID
+func parseID(mention *regexp.Regexp, value string) (string, bool) {
+	match := mention.FindStringSubmatch(value)
+	if len(match) == 2 {
+		return match[1], true
+	}
+	if MatchSnowflake.MatchString(value) {
+		return value, true
+	}
+	return "", false
+}
+
+// trimQuotes removes the quotes around a line that is entirely "quoted", so a
+// rest argument reads the same whether or not it was quoted
+func trimQuotes(line string) string {
+	if len(line) >= 2 && strings.Count(line, `"`) == 2 && strings.HasPrefix(line, `"`) && strings.HasSuffix(line, `"`) {
+		return line[1 : len(line)-1]
+	}
+	return line
+}
+
+type token struct {
+	value  string
+	offset int
+}
+
+// tokenise splits a string on whitespace, treating "double quoted" sections as
+// a single token and recording where in the string each token began
+func tokenise(raw string) (tokens []token) {
+	var (
+		buf     bytes.Buffer
+		quoted  bool
+		inToken bool
+		start   int
+	)
+
+	flush := func() {
+		if inToken {
+			tokens = append(tokens, token{value: buf.String(), offset: start})
+		}
+		buf.Reset()
+		inToken = false
+	}
+
+	for i, r := range raw {
+		switch {
+		case r == '"':
+			if !inToken {
+				inToken = true
+				start = i
+			}
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			if !inToken {
+				inToken = true
+				start = i
+			}
+			buf.WriteRune(r)
+		}
+	}
+	flush()
+
+	return
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTokenise(t *testing.T) {
+	for _, tt := range []struct {
+		raw  string
+		want string
+	}{
+		{"", "[]"},
+		{"one", "[{one 0}]"},
+		{"  one   two ", "[{one 2} {two 8}]"},
+		{`"John Smith" 10`, "[{John Smith 0} {10 13}]"},
+		{`say "hello there"`, "[{say 0} {hello there 4}]"},
+		{`""`, "[{ 0}]"},
+		{`"unterminated quote`, "[{unterminated quote 0}]"},
+		{"tab\tand\nnewline", "[{tab 0} {and 4} {newline 8}]"},
+	} {
+		if got := fmt.Sprint(tokenise(tt.raw)); got != tt.want {
+			t.Errorf("tokenise(%q) = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseArguments(t *testing.T) {
+	command := Command{
+		Arguments: []Argument{
+			{Name: "user", Type: ArgumentUser},
+			{Name: "duration", Type: ArgumentDuration, Optional: true},
+			{Name: "reason", Type: ArgumentRest, Optional: true},
+		},
+	}
+	single := Command{
+		Arguments: []Argument{
+			{Name: "name", Type: ArgumentString},
+		},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		command     Command
+		raw         string
+		want        Arguments
+		wantErr     bool
+		wantMissing bool
+		wantPos     int
+	}{
+		{
+			name: "mention", command: command, raw: "<@!123>",
+			want: Arguments{"user": "123"},
+		},
+		{
+			name: "every argument", command: command, raw: "123 1h30m  being rude  in chat ",
+			want: Arguments{"user": "123", "duration": 90 * time.Minute, "reason": "being rude  in chat"},
+		},
+		{
+			name: "quoted rest", command: command, raw: `123 1h "being rude"`,
+			want: Arguments{"user": "123", "duration": time.Hour, "reason": "being rude"},
+		},
+		{
+			name: "rest with quotes inside", command: command, raw: `123 1h said "hi" twice`,
+			want: Arguments{"user": "123", "duration": time.Hour, "reason": `said "hi" twice`},
+		},
+		{
+			name: "quoted string", command: single, raw: `"John Smith"`,
+			want: Arguments{"name": "John Smith"},
+		},
+		{
+			name: "missing required", command: command, raw: "",
+			want: Arguments{}, wantErr: true, wantMissing: true, wantPos: 0,
+		},
+		{
+			name: "invalid value", command: command, raw: "123 soon",
+			want: Arguments{"user": "123"}, wantErr: true, wantPos: 1,
+		},
+		{
+			name: "too many", command: single, raw: "John Smith",
+			want: Arguments{"name": "John"}, wantErr: true, wantPos: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := tt.command.ParseArguments(tt.raw)
+
+			if fmt.Sprint(args) != fmt.Sprint(tt.want) {
+				t.Errorf("args = %v, want %v", args, tt.want)
+			}
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("err = %v, want none", err)
+				}
+				return
+			}
+			argErr, ok := err.(ArgumentError)
+			if !ok {
+				t.Fatalf("err = %v, want an ArgumentError", err)
+			}
+			if argErr.Missing() != tt.wantMissing || argErr.Position != tt.wantPos {
+				t.Errorf("err = %+v, want missing %v at %d", argErr, tt.wantMissing, tt.wantPos)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Southclaws/maccer/types"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+func (app *App) commandForceLink(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	discordID := args.String("user")
+	forumID, ok := parseForumID(args.String("forum ID or profile URL"))
+	if !ok {
+		return false, nil
+	}
+
+	member, err := app.FetchForumMember(ctx, forumID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get member data from forum API")
+	}
+	if member.ID == 0 {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID, fmt.Sprintf("Forum account %s does not exist.", forumID))
+		return true, err
+	}
+
+	// nothing has changed yet, so stop here if the forum took too long
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	byDiscord, discordLinked, err := app.GetUserByDiscord(discordID)
+	if err != nil {
+		return false, err
+	}
+	byForum, forumLinked, err := app.GetUserByForum(forumID)
+	if err != nil {
+		return false, err
+	}
+
+	if discordLinked && byDiscord.ForumID == forumID {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID, "Those accounts are already linked.")
+		return true, err
+	}
+
+	user := types.User{
+		DiscordID: discordID,
+		ForumID:   forumID,
+	}
+
+	// existing links are replaced rather than deleted first so a failure leaves
+	// them as they were
+	var previous []types.User
+	switch {
+	case discordLinked && forumLinked:
+		previous = []types.User{byDiscord, byForum}
+		err = app.replaceTwoLinks(byDiscord, byForum, user)
+	case discordLinked:
+		previous = []types.User{byDiscord}
+		err = app.ReplaceUser(byDiscord, user)
+	case forumLinked:
+		previous = []types.User{byForum}
+		err = app.ReplaceUser(byForum, user)
+	default:
+		err = app.CreateUser(user)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if forumLinked {
+		err = app.RevokeRoles(byForum.DiscordID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	guildMember, err := app.discordClient.GuildMember(app.Config().GuildID, discordID)
+	if err == nil {
+		_, err = app.GrantRoles(guildMember, member)
+		if err != nil {
+			return false, err
+		}
+	} else if !isNotFound(err) {
+		return false, errors.Wrap(err, "failed to get guild member")
+	}
+
+	err = app.Audit(types.AuditEntry{
+		AdminID:   message.Author.ID,
+		Action:    "forcelink",
+		DiscordID: discordID,
+		ForumID:   forumID,
+		Previous:  previous,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+		fmt.Sprintf("Linked <@%s> to forum account %s (%s).", discordID, forumID, member.Name))
+	return true, err
+}
+
+func (app *App) commandForceUnlink(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	discordID := args.String("user")
+
+	user, exists, err := app.GetUserByDiscord(discordID)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID, fmt.Sprintf("<@%s> is not linked to a forum account.", discordID))
+		return true, err
+	}
+
+	err = app.DeleteUser(user.DiscordID)
+	if err != nil {
+		return false, err
+	}
+
+	err = app.RevokeRoles(user.DiscordID)
+	if err != nil {
+		return false, err
+	}
+
+	err = app.Audit(types.AuditEntry{
+		AdminID:   message.Author.ID,
+		Action:    "forceunlink",
+		DiscordID: user.DiscordID,
+		ForumID:   user.ForumID,
+		Previous:  []types.User{user},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+		fmt.Sprintf("Unlinked <@%s> from forum account %s.", user.DiscordID, user.ForumID))
+	return true, err
+}
+
+func (app *App) commandRecord(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	discordID := args.String("user")
+
+	user, exists, err := app.GetUserByDiscord(discordID)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID, fmt.Sprintf("<@%s> is not linked to a forum account.", discordID))
+		return true, err
+	}
+
+	raw, err := json.MarshalIndent(user, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	err = app.Audit(types.AuditEntry{
+		AdminID:   message.Author.ID,
+		Action:    "record",
+		DiscordID: user.DiscordID,
+		ForumID:   user.ForumID,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	_, err = app.discordClient.ChannelMessageSend(message.ChannelID, fmt.Sprintf("```json\n%s\n```", raw))
+	return true, err
+}
+
+// replaceTwoLinks links a Discord account and a forum account that are each
+// linked to something else. Two links can't be swapped in one step, so the
+// forum account's link is removed first and restored if the replacement fails.
+func (app *App) replaceTwoLinks(byDiscord, byForum, replacement types.User) (err error) {
+	err = app.DeleteUserByForum(byForum.ForumID)
+	if err != nil {
+		return
+	}
+
+	err = app.ReplaceUser(byDiscord, replacement)
+	if err != nil {
+		restoreErr := app.CreateUser(byForum)
+		if restoreErr != nil {
+			return errors.Wrapf(restoreErr, "failed to restore link of <@%s> to forum %s after: %v",
+				byForum.DiscordID, byForum.ForumID, err)
+		}
+	}
+	return
+}
+
+// Audit stores a record of an administrative action and announces it in the
+// logging channel
+func (app *App) Audit(entry types.AuditEntry) (err error) {
+	entry.Time = time.Now()
+
+	logger.Info("administrative action",
+		zap.Any("entry", entry))
+
+	err = app.CreateAuditEntry(entry)
+	if err != nil {
+		return
+	}
+
+	_, err = app.discordClient.ChannelMessageSend(app.Config().LogChannel, fmt.Sprintf(
+		"**Audit:** <@%s> ran `%s` on <@%s> (forum %s)",
+		entry.AdminID, entry.Action, entry.DiscordID, entry.ForumID))
+	return
+}
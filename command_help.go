@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func (app *App) commandHelp(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	cm := app.commandManager
+	prefix := app.Config().CommandPrefix
+
+	source, err := cm.getCommandSource(message)
+	if err != nil {
+		return false, err
+	}
+
+	if args.Has("command") {
+		trigger := strings.ToLower(strings.TrimPrefix(args.String("command"), prefix))
+		command, exists := cm.Commands[trigger]
+		if !exists {
+			_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+				fmt.Sprintf("There is no command called `%s`, use `%shelp` to list them.", trigger, prefix))
+			return true, err
+		}
+
+		_, err = app.discordClient.ChannelMessageSendEmbed(message.ChannelID,
+			commandEmbed(prefix, trigger, command, cm.DescribeSources(command)))
+		return true, err
+	}
+
+	// the member is fetched once, and only if a command needs their roles
+	var (
+		roles   map[string]bool
+		fetched bool
+	)
+	var triggers []string
+	for trigger, command := range cm.Commands {
+		if !cm.Allowed(command, source, message.ChannelID) {
+			continue
+		}
+		if (command.RequireVerified || command.RequireAdmin) && !fetched {
+			roles, err = cm.memberRoles(message.Author.ID)
+			if err != nil {
+				return false, err
+			}
+			fetched = true
+		}
+		if cm.rolesDenial(command, trigger, roles) != "" {
+			continue
+		}
+		triggers = append(triggers, trigger)
+	}
+	sort.Strings(triggers)
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Commands",
+		Description: fmt.Sprintf("Use `%shelp <command>` for more information about a command.", prefix),
+	}
+	for _, trigger := range triggers {
+		command := cm.Commands[trigger]
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  command.Usage(prefix + trigger),
+			Value: command.Description,
+		})
+	}
+	if len(triggers) == 0 {
+		embed.Description = "There are no commands you can use here."
+	}
+
+	_, err = app.discordClient.ChannelMessageSendEmbed(message.ChannelID, embed)
+	return true, err
+}
+
+// suggestCommands lists the command triggers that start with `partial`
+func (app *App) suggestCommands(partial string) (triggers []string) {
+	for trigger := range app.commandManager.Commands {
+		if strings.HasPrefix(trigger, strings.ToLower(partial)) {
+			triggers = append(triggers, trigger)
+		}
+	}
+	sort.Strings(triggers)
+	return
+}
+
+// commandEmbed renders the full details of a single command
+func commandEmbed(prefix, trigger string, command Command, where string) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       command.Usage(prefix + trigger),
+		Description: command.Description,
+	}
+
+	if command.Example != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Example",
+			Value: prefix + command.Example,
+		})
+	}
+
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+		Name:   "Where",
+		Value:  where,
+		Inline: true,
+	})
+
+	var requires []string
+	if command.RequireVerified {
+		requires = append(requires, "verified forum account")
+	}
+	if command.RequireAdmin {
+		requires = append(requires, "administrator")
+	}
+	if len(requires) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Requires",
+			Value:  strings.Join(requires, ", "),
+			Inline: true,
+		})
+	}
+
+	return embed
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+func (app *App) commandLookup(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	query := args.String("forum profile URL, ID or username")
+
+	forumID, ok := parseForumID(query)
+	if !ok {
+		forumID, ok, err = app.resolveForumName(ctx, query, message.ChannelID)
+		if err != nil || !ok {
+			return true, err
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	user, exists, err := app.GetUserByForum(forumID)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+			fmt.Sprintf("Forum account %s is not linked to a Discord account.", forumID))
+		return true, err
+	}
+
+	presence := "is in the server"
+	_, err = app.discordClient.GuildMember(app.Config().GuildID, user.DiscordID)
+	if err != nil {
+		if !isNotFound(err) {
+			return false, errors.Wrap(err, "failed to get guild member")
+		}
+		presence = "is no longer in the server"
+	}
+
+	_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+		fmt.Sprintf("Forum account %s is linked to <@%s> (%s), who %s.", forumID, user.DiscordID, user.DiscordID, presence))
+	return true, err
+}
+
+// resolveForumName searches the forum for a username and returns the forum ID
+// of an exact match, replying with the candidates if there is no single match
+func (app *App) resolveForumName(ctx context.Context, name, channelID string) (forumID string, ok bool, err error) {
+	members, err := app.SearchForumMembers(ctx, name)
+	if err != nil {
+		return
+	}
+
+	for _, member := range members {
+		if strings.EqualFold(member.Name, name) {
+			return fmt.Sprint(member.ID), true, nil
+		}
+	}
+
+	if len(members) == 0 {
+		_, err = app.discordClient.ChannelMessageSend(channelID, fmt.Sprintf("No forum accounts found matching `%s`.", name))
+		return
+	}
+
+	buf := bytes.NewBufferString(fmt.Sprintf("No exact match for `%s`, did you mean:\n", name))
+	for i, member := range members {
+		if i == 10 {
+			break
+		}
+		buf.WriteString(fmt.Sprintf("%s (%d)\n", member.Name, member.ID))
+	}
+	_, err = app.discordClient.ChannelMessageSend(channelID, buf.String())
+	return
+}
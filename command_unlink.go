@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func (app *App) commandUnlink(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	user, exists, err := app.GetUserByDiscord(message.Author.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+			"Your Discord account is not linked to a forum account.")
+		return true, err
+	}
+
+	err = app.DeleteUser(user.DiscordID)
+	if err != nil {
+		return false, err
+	}
+
+	err = app.RevokeRoles(user.DiscordID)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = app.discordClient.ChannelMessageSend(message.ChannelID, fmt.Sprintf(
+		"Your Discord account has been unlinked from forum account %s. Use `verify` to link a forum account again.",
+		user.ForumID))
+	return true, err
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/Southclaws/invision-community-go"
@@ -16,12 +18,38 @@ import (
 // MatchURL matches a user's profile URL and captures the ID
 var MatchURL = regexp.MustCompile(`https:\/\/forum\.bayarearoleplay\.com\/profile\/([0-9]*)-(\w+)(\/)?`)
 
-func (app *App) commandVerify(args string, message discordgo.Message, contextual bool) (success bool, err error) {
+// AskUserVerify is sent to new members who have not linked a forum account yet
+const AskUserVerify = `Welcome to Bay Area Roleplay!
+
+To gain access to the server, please verify that you own a forum account by replying here with:
+
+` + "`verify <profile page URL>`" + `
+
+Your profile page can be accessed here: https://i.imgur.com/htrHTvV.png
+
+For more help, please read: https://forum.bayarearoleplay.com/topic/705-how-to-verify-your-discord-account/`
+
+// parseForumID accepts either a numeric forum ID or a profile URL and returns
+// the forum ID
+func parseForumID(arg string) (string, bool) {
+	if _, err := strconv.Atoi(arg); err == nil {
+		return arg, true
+	}
+	match := MatchURL.FindStringSubmatch(arg)
+	if len(match) < 2 {
+		return "", false
+	}
+	return match[1], true
+}
+
+func (app *App) commandVerify(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	profileURL := args.URL("profile page URL").String()
+
 	logger.Debug("verification request received",
-		zap.String("url", args),
+		zap.String("url", profileURL),
 		zap.String("userID", message.Author.ID))
 
-	match := MatchURL.FindStringSubmatch(args)
+	match := MatchURL.FindStringSubmatch(profileURL)
 
 	if len(match) < 2 {
 		_, err = app.discordClient.ChannelMessageSend(
@@ -37,18 +65,40 @@ For more help, please read: https://forum.bayarearoleplay.com/topic/705-how-to-v
 
 	userID := match[1]
 
-	_, err = app.ipsClient.GetMember(userID)
+	_, err = app.FetchForumMember(ctx, userID)
 	if err != nil {
 		return false, err
 	}
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
 
-	code := uuid.New().String()
+	verification := types.Verification{
+		DiscordID: message.Author.ID,
+		ForumID:   userID,
+		Code:      uuid.New().String(),
+		ChannelID: message.ChannelID,
+		Expires:   time.Now().Add(time.Minute * 5),
+	}
+
+	verification.Replaces, err = app.relinkTarget(verification, message.ChannelID)
+	if err != nil {
+		if err == errRelinkRefused {
+			return true, nil
+		}
+		return false, err
+	}
+
+	err = app.CreateVerification(verification)
+	if err != nil {
+		return false, err
+	}
 
 	_, err = app.discordClient.ChannelMessageSend(message.ChannelID, "***-- Verification --***\nPlease paste this unique token into the **Discord** > **Verification Code** section of your profile:")
 	if err != nil {
 		return false, err
 	}
-	_, err = app.discordClient.ChannelMessageSend(message.ChannelID, fmt.Sprintf("`%s`", code))
+	_, err = app.discordClient.ChannelMessageSend(message.ChannelID, fmt.Sprintf("`%s`", verification.Code))
 	if err != nil {
 		return false, err
 	}
@@ -57,87 +107,212 @@ For more help, please read: https://forum.bayarearoleplay.com/topic/705-how-to-v
 		return false, err
 	}
 
+	verificationEvents.Inc("started")
+	app.commandManager.Limiter.Hold("verify", verification.DiscordID)
+	app.Go(func(ctx context.Context) {
+		app.pollVerification(ctx, verification)
+	})
+
+	return true, nil
+}
+
+// errRelinkRefused is returned by relinkTarget when the verification must not
+// go ahead, the user has already been told why
+var errRelinkRefused = errors.New("relink refused")
+
+// relinkTarget checks whether either side of a verification is already linked
+// and returns the existing link that the verification will replace, if any.
+func (app *App) relinkTarget(verification types.Verification, channelID string) (replaces *types.User, err error) {
+	byDiscord, discordLinked, err := app.GetUserByDiscord(verification.DiscordID)
+	if err != nil {
+		return
+	}
+	byForum, forumLinked, err := app.GetUserByForum(verification.ForumID)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case discordLinked && byDiscord.ForumID == verification.ForumID:
+		_, err = app.discordClient.ChannelMessageSend(channelID,
+			"Your Discord account is already linked to that forum account.")
+	case discordLinked && forumLinked:
+		_, err = app.discordClient.ChannelMessageSend(channelID,
+			"Your Discord account and that forum account are both already linked to other accounts. Use `unlink` first, then verify again.")
+	case discordLinked:
+		_, err = app.discordClient.ChannelMessageSend(channelID, fmt.Sprintf(
+			"Your Discord account is currently linked to forum account %s, completing this verification will replace that link.",
+			byDiscord.ForumID))
+		return &byDiscord, err
+	case forumLinked:
+		_, err = app.discordClient.ChannelMessageSend(channelID,
+			"That forum account is currently linked to another Discord account, completing this verification will move the link to this Discord account.")
+		return &byForum, err
+	default:
+		return nil, nil
+	}
+
+	if err == nil {
+		err = errRelinkRefused
+	}
+	return
+}
+
+// ResumeVerifications restarts polling for any verifications that were still
+// pending when the bot last stopped
+func (app *App) ResumeVerifications() {
+	verifications, err := app.GetVerifications()
+	if err != nil {
+		app.ChannelLogError(err)
+		return
+	}
+
+	for _, verification := range verifications {
+		logger.Debug("resuming verification",
+			zap.String("userID", verification.DiscordID),
+			zap.Time("expires", verification.Expires))
+
+		app.commandManager.Limiter.Hold("verify", verification.DiscordID)
+		verification := verification
+		app.Go(func(ctx context.Context) {
+			app.pollVerification(ctx, verification)
+		})
+	}
+}
+
+// pollVerification checks the user's forum profile for their verification code
+// until it appears or the verification expires, then removes the stored session
+// and releases the active verify slot held for the user. If ctx is done first,
+// the stored session is kept so it can be resumed on the next start.
+func (app *App) pollVerification(ctx context.Context, verification types.Verification) {
+	var (
+		member    ips.Member
+		inlineErr error
+	)
+
+	defer app.commandManager.Limiter.Release("verify", verification.DiscordID)
+
 	ticker := time.NewTicker(time.Second * 5)
-	timer := time.NewTimer(time.Minute * 5)
-	go func() {
-		var (
-			member    ips.Member
-			inlineErr error
-		)
-
-	loop:
-		for {
-			select {
-			case <-ticker.C:
-				member, inlineErr = app.ipsClient.GetMember(userID)
-				if inlineErr != nil {
-					inlineErr = errors.Wrap(err, "failed to get member data from forum API")
-					break loop
-				}
+	defer ticker.Stop()
+	timer := time.NewTimer(time.Until(verification.Expires))
+	defer timer.Stop()
 
-				fieldGroups, ok := member.CustomFields["Discord"]
-				if !ok {
-					inlineErr = errors.New("no Discord field in member custom fields")
-					break loop
-				}
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			member, inlineErr = app.FetchForumMember(ctx, verification.ForumID)
+			if ctx.Err() != nil {
+				// the fetch was aborted by shutdown, which is not a failure
+				logger.Debug("verification suspended for shutdown",
+					zap.String("userID", verification.DiscordID))
+				return
+			}
+			if inlineErr != nil {
+				inlineErr = errors.Wrap(inlineErr, "failed to get member data from forum API")
+				break loop
+			}
 
-				gotCode, ok := fieldGroups["Verification Code"]
-				if ok && len(gotCode) >= 8 {
-					if gotCode == code {
-						user := types.User{
-							DiscordID: message.Author.ID,
-							ForumID:   userID,
-						}
+			fieldGroups, ok := member.CustomFields["Discord"]
+			if !ok {
+				inlineErr = errors.New("no Discord field in member custom fields")
+				break loop
+			}
+
+			gotCode, ok := fieldGroups["Verification Code"]
+			if ok && len(gotCode) >= 8 {
+				if gotCode == verification.Code {
+					// a banned or validating account must not be linked, since
+					// that would hand out the verified role
+					if valid, reason := app.MemberValid(member); !valid {
+						verificationEvents.Inc("refused")
+						_, inlineErr = app.discordClient.ChannelMessageSend(
+							verification.ChannelID,
+							fmt.Sprintf("Your accounts could not be linked because your %s.", reason))
+						break loop
+					}
 
+					user := types.User{
+						DiscordID: verification.DiscordID,
+						ForumID:   verification.ForumID,
+					}
+
+					if verification.Replaces != nil {
+						inlineErr = app.relink(*verification.Replaces, user)
+					} else {
 						inlineErr = app.CreateUser(user)
-						if inlineErr != nil {
-							inlineErr = errors.Wrap(err, "failed to update user in database")
-							break loop
-						}
-
-						inlineErr = app.discordClient.GuildMemberRoleAdd(
-							app.config.GuildID,
-							message.Author.ID,
-							app.config.VerifiedRole,
-						)
-						if inlineErr != nil {
-							inlineErr = errors.Wrap(err, "failed to add member to role")
-							break loop
-						}
-
-						_, inlineErr = app.discordClient.ChannelMessageSend(message.ChannelID, "Your accounts have been linked and you have been verified!")
-						if inlineErr != nil {
-							inlineErr = errors.Wrap(err, "failed to send private message")
-							break loop
-						}
+					}
+					if inlineErr != nil {
+						inlineErr = errors.Wrap(inlineErr, "failed to update user in database")
+						break loop
+					}
 
+					var guildMember *discordgo.Member
+					guildMember, inlineErr = app.discordClient.GuildMember(app.Config().GuildID, verification.DiscordID)
+					if inlineErr != nil {
+						inlineErr = errors.Wrap(inlineErr, "failed to get guild member")
 						break loop
-					} /* else {
-						_, inlineErr = app.discordClient.ChannelMessageSend(
-							message.ChannelID,
-							fmt.Sprintf("The codes did not match, the code you were given was '%s' and the code on your profile was '%s'",
-								code, gotCode))
-						if inlineErr != nil {
-							break loop
-						}
-					}*/
-				}
+					}
+
+					_, inlineErr = app.GrantRoles(guildMember, member)
+					if inlineErr != nil {
+						break loop
+					}
 
-				logger.Debug("no code yet",
-					zap.Any("customFields", fieldGroups))
+					_, inlineErr = app.discordClient.ChannelMessageSend(verification.ChannelID, "Your accounts have been linked and you have been verified!")
+					if inlineErr != nil {
+						inlineErr = errors.Wrap(inlineErr, "failed to send private message")
+						break loop
+					}
 
-			case <-timer.C:
-				_, inlineErr = app.discordClient.ChannelMessageSend(
-					message.ChannelID,
-					"Your time has expired, please try again.")
-				break loop
+					verificationEvents.Inc("completed")
+					break loop
+				}
 			}
-		}
 
-		if inlineErr != nil {
-			app.ChannelLogError(inlineErr)
+			logger.Debug("no code yet",
+				zap.Any("customFields", fieldGroups))
+
+		case <-timer.C:
+			verificationEvents.Inc("expired")
+			_, inlineErr = app.discordClient.ChannelMessageSend(
+				verification.ChannelID,
+				"Your time has expired, please try again.")
+			break loop
+
+		case <-ctx.Done():
+			logger.Debug("verification suspended for shutdown",
+				zap.String("userID", verification.DiscordID))
+			return
 		}
-	}()
+	}
 
-	return true, nil
+	if inlineErr != nil {
+		verificationEvents.Inc("failed")
+		app.ChannelLogError(inlineErr)
+	}
+
+	inlineErr = app.DeleteVerification(verification.DiscordID)
+	if inlineErr != nil {
+		app.ChannelLogError(inlineErr)
+	}
+}
+
+// relink replaces an existing link and, if the link moved to a different
+// Discord account, strips the managed roles from the old account
+func (app *App) relink(old, replacement types.User) (err error) {
+	err = app.ReplaceUser(old, replacement)
+	if err != nil {
+		return
+	}
+
+	logger.Debug("relinked user",
+		zap.Any("old", old),
+		zap.Any("new", replacement))
+
+	if old.DiscordID == replacement.DiscordID {
+		return
+	}
+
+	return app.RevokeRoles(old.DiscordID)
 }
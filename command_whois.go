@@ -1,10 +1,73 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Southclaws/invision-community-go"
 	"github.com/bwmarrin/discordgo"
 )
 
-func (app *App) commandWhoIs(args string, message discordgo.Message, contextual bool) (success bool, err error) {
-	// todo
-	return true, nil
+func (app *App) commandWhoIs(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (success bool, err error) {
+	discordID := args.String("user")
+
+	user, exists, err := app.GetUserByDiscord(discordID)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+			fmt.Sprintf("<@%s> has not linked a forum account.", discordID))
+		return true, err
+	}
+
+	member, err := app.GetForumMember(ctx, user.ForumID)
+	if err != nil {
+		return false, err
+	}
+	// a zero member is the forum saying the account is gone, failing to ask it
+	// is an error above
+	if member.ID == 0 {
+		_, err = app.discordClient.ChannelMessageSend(message.ChannelID,
+			fmt.Sprintf("<@%s> is linked to forum account %s which no longer exists.", discordID, user.ForumID))
+		return true, err
+	}
+
+	_, err = app.discordClient.ChannelMessageSendEmbed(message.ChannelID, memberEmbed(discordID, member))
+	return true, err
+}
+
+// memberEmbed renders a forum member's profile as a Discord embed
+func memberEmbed(discordID string, member ips.Member) *discordgo.MessageEmbed {
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2 January 2006")
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       member.Name,
+		URL:         member.ProfileURL,
+		Description: fmt.Sprintf("Forum account of <@%s>", discordID),
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: member.PhotoURL,
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Group", Value: orDash(stripTags(member.PrimaryGroup.FormattedName)), Inline: true},
+			{Name: "Title", Value: orDash(member.Title), Inline: true},
+			{Name: "Posts", Value: fmt.Sprint(member.Posts), Inline: true},
+			{Name: "Reputation", Value: fmt.Sprint(member.ReputationPoints), Inline: true},
+			{Name: "Joined", Value: formatTime(member.Joined), Inline: true},
+			{Name: "Last Active", Value: formatTime(member.LastActivity), Inline: true},
+			{Name: "Profile", Value: orDash(member.ProfileURL)},
+		},
+	}
 }
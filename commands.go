@@ -1,52 +1,129 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 // Command represents a public, private or administrative command
 type Command struct {
 	commandManager  *CommandManager
-	Function        func(args string, message discordgo.Message, contextual bool) (bool, error)
-	Source          CommandSource
-	ParametersRange CommandParametersRange
+	Function        func(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (bool, error)
+	Sources         []CommandSource
+	Arguments       []Argument
 	Description     string
-	Usage           string
 	Example         string
 	RequireVerified bool
 	RequireAdmin    bool
 	Context         bool
+	Cooldown        Cooldown
+	Concurrency     int
 }
 
 // LoadCommands is called on initialisation and is responsible for registering
 // all commands and binding them to functions.
 func LoadCommands(app *App) map[string]Command {
 	return map[string]Command{
+		"help": {
+			Function:    app.commandHelp,
+			Sources:     []CommandSource{CommandSourcePRIVATE, CommandSourcePRIMARY, CommandSourceADMINISTRATIVE, CommandSourceOTHER},
+			Description: "List the commands you can use, or show the details of one command",
+			Example:     "help verify",
+			Arguments: []Argument{
+				{Name: "command", Type: ArgumentString, Optional: true, Suggest: app.suggestCommands},
+			},
+			RequireVerified: false,
+			RequireAdmin:    false,
+			Context:         false,
+		},
 		"verify": {
 			Function:    app.commandVerify,
-			Source:      CommandSourcePRIVATE,
+			Sources:     []CommandSource{CommandSourcePRIVATE},
 			Description: "Verify you are the owner of a Bay Area Roleplay forum account",
-			Usage:       "verify <profile page URL>\nYour profile page can be accessed here: https://i.imgur.com/htrHTvV.png",
-			ParametersRange: CommandParametersRange{
-				Minimum: 1,
-				Maximum: 1,
+			Example:     "verify https://forum.bayarearoleplay.com/profile/21-southclaws/\nYour profile page can be accessed here: https://i.imgur.com/htrHTvV.png",
+			Arguments: []Argument{
+				{
+					Name:   "profile page URL",
+					Type:   ArgumentURL,
+					Prompt: "Please send me the URL of your forum profile page, you can find it here: https://i.imgur.com/htrHTvV.png\nSay `cancel` to stop.",
+				},
 			},
 			RequireVerified: false,
 			RequireAdmin:    false,
 			Context:         true,
+			Cooldown:        Cooldown{Limit: 3, Window: time.Minute * 15},
+			Concurrency:     1,
+		},
+		"unlink": {
+			Function:        app.commandUnlink,
+			Sources:         []CommandSource{CommandSourcePRIVATE},
+			Description:     "Unlink your Discord account from your Bay Area Roleplay forum account",
+			RequireVerified: false,
+			RequireAdmin:    false,
+			Context:         false,
+		},
+		"forcelink": {
+			Function:    app.commandForceLink,
+			Sources:     []CommandSource{CommandSourceADMINISTRATIVE},
+			Description: "Link a Discord user to a forum account without verification",
+			Example:     "forcelink @Southclaws 21",
+			Arguments: []Argument{
+				{Name: "user", Type: ArgumentUser},
+				{Name: "forum ID or profile URL", Type: ArgumentString},
+			},
+			RequireVerified: false,
+			RequireAdmin:    true,
+			Context:         false,
+		},
+		"forceunlink": {
+			Function:    app.commandForceUnlink,
+			Sources:     []CommandSource{CommandSourceADMINISTRATIVE},
+			Description: "Remove the link between a Discord user and their forum account",
+			Example:     "forceunlink @Southclaws",
+			Arguments: []Argument{
+				{Name: "user", Type: ArgumentUser},
+			},
+			RequireVerified: false,
+			RequireAdmin:    true,
+			Context:         false,
+		},
+		"record": {
+			Function:    app.commandRecord,
+			Sources:     []CommandSource{CommandSourceADMINISTRATIVE},
+			Description: "Show the stored link record for a Discord user",
+			Example:     "record @Southclaws",
+			Arguments: []Argument{
+				{Name: "user", Type: ArgumentUser},
+			},
+			RequireVerified: false,
+			RequireAdmin:    true,
+			Context:         false,
+		},
+		"lookup": {
+			Function:    app.commandLookup,
+			Sources:     []CommandSource{CommandSourceADMINISTRATIVE},
+			Description: "Find the Discord account linked to a forum account",
+			Example:     "lookup https://forum.bayarearoleplay.com/profile/21-southclaws/",
+			Arguments: []Argument{
+				{Name: "forum profile URL, ID or username", Type: ArgumentRest},
+			},
+			RequireVerified: false,
+			RequireAdmin:    true,
+			Context:         false,
 		},
 		"whois": {
 			Function:    app.commandWhoIs,
-			Source:      CommandSourcePRIMARY,
+			Sources:     []CommandSource{CommandSourcePRIMARY, CommandSourcePRIVATE, CommandSourceADMINISTRATIVE, CommandSourceOTHER},
 			Description: "Get a Discord users' forum account",
-			Usage:       "whois @user",
-			ParametersRange: CommandParametersRange{
-				Minimum: 1,
-				Maximum: 1,
+			Example:     "whois @Southclaws",
+			Arguments: []Argument{
+				{Name: "user", Type: ArgumentUser},
 			},
 			RequireVerified: true,
 			RequireAdmin:    false,
@@ -73,23 +150,43 @@ const (
 	CommandSourceOTHER CommandSource = iota
 )
 
+func (source CommandSource) String() string {
+	switch source {
+	case CommandSourceADMINISTRATIVE:
+		return "administrative channel"
+	case CommandSourcePRIMARY:
+		return "primary channel"
+	case CommandSourcePRIVATE:
+		return "direct message"
+	}
+	return "other channels"
+}
+
 // CommandManager stores command state
 type CommandManager struct {
-	App      *App
-	Commands map[string]Command
+	App           *App
+	Session       Session
+	Commands      map[string]Command
+	Conversations *ConversationManager
+	Limiter       *Limiter
 }
 
-// CommandParametersRange represents minimum value and maximum value number of parameters for a command
-type CommandParametersRange struct {
-	Minimum int
-	Maximum int
+// Session is the subset of the Discord session that the command manager uses,
+// it allows commands to be processed against something other than Discord
+type Session interface {
+	Channel(channelID string) (*discordgo.Channel, error)
+	ChannelMessageSend(channelID string, content string) (*discordgo.Message, error)
+	ChannelTyping(channelID string) error
+	GuildMember(guildID, userID string) (*discordgo.Member, error)
 }
 
 // StartCommandManager creates a command manager for the app
 func (app *App) StartCommandManager() {
 	app.commandManager = &CommandManager{
-		App:      app,
-		Commands: make(map[string]Command),
+		App:           app,
+		Commands:      make(map[string]Command),
+		Conversations: NewConversationManager(app, app.Config().ConversationTimeout),
+		Limiter:       NewLimiter(app.cache),
 	}
 
 	app.commandManager.Commands = LoadCommands(app)
@@ -98,20 +195,30 @@ func (app *App) StartCommandManager() {
 // Process is called on a command string to check whether it's a valid command
 // and, if so, call the associated function.
 // nolint:gocyclo
-func (cm CommandManager) Process(message discordgo.Message) (exists bool, source CommandSource, err error) {
+func (cm CommandManager) Process(ctx context.Context, message discordgo.Message) (exists bool, source CommandSource, err error) {
 	source, err = cm.getCommandSource(message)
 	if err != nil {
 		return
 	}
 
-	commandAndParameters := strings.SplitN(message.Content, " ", 2)
-	commandParametersCount := 0
+	// Messages from a user in a conversation are answers, not commands.
+	if source == CommandSourcePRIVATE && cm.Conversations.Active(message.Author.ID) {
+		exists = true
+		err = cm.Conversations.Handle(ctx, message)
+		return
+	}
+
+	content, ok := cm.stripPrefix(message.Content, source)
+	if !ok {
+		return
+	}
+
+	commandAndParameters := strings.SplitN(content, " ", 2)
 	commandTrigger := strings.ToLower(commandAndParameters[0])
 	commandArgument := ""
 
 	if len(commandAndParameters) > 1 {
 		commandArgument = commandAndParameters[1]
-		commandParametersCount = strings.Count(commandArgument, " ") + 1
 	}
 
 	commandObject, exists := cm.Commands[commandTrigger]
@@ -121,84 +228,228 @@ func (cm CommandManager) Process(message discordgo.Message) (exists bool, source
 		return
 	}
 
-	if source != commandObject.Source {
+	denial, err := cm.authorise(commandObject, commandTrigger, source, message)
+	if err != nil {
+		return
+	}
+	if denial != "" {
+		_, err = cm.Session.ChannelMessageSend(message.ChannelID, denial)
+		return
+	}
+
+	// Check the parameters match the command's arguments.
+	args, argErr := commandObject.ParseArguments(commandArgument)
+	if missing, ok := argErr.(ArgumentError); ok && missing.Missing() && commandObject.Context && source == CommandSourcePRIVATE {
+		// Contextual commands ask for their missing arguments instead.
+		err = cm.Conversations.Start(message.Author.ID, message.ChannelID, Conversation{
+			Steps:   commandObject.Arguments[missing.Position:],
+			Answers: args,
+			Complete: func(ctx context.Context, answers Arguments, answer discordgo.Message) error {
+				return cm.run(ctx, commandObject, commandTrigger, answers, answer, true)
+			},
+		})
 		return
 	}
+	if argErr != nil {
+		logger.Debug("ignoring command with invalid arguments",
+			zap.String("command", commandTrigger),
+			zap.Error(argErr))
 
-	switch source {
-	case CommandSourceADMINISTRATIVE:
-		if message.ChannelID != cm.App.config.AdministrativeChannel {
+		commandsProcessed.Inc(commandTrigger, "invalid")
+		_, err = cm.Session.ChannelMessageSend(message.ChannelID,
+			fmt.Sprintf("Sorry, %s.\n%s", argErr, commandObject.Help(cm.App.Config().CommandPrefix, commandTrigger)))
+		return
+	}
+
+	err = cm.run(ctx, commandObject, commandTrigger, args, message, false)
+	return
+}
+
+// Reject replies to a message with the reason it can't be processed right now,
+// if it is a command or an answer in a conversation, and ignores it otherwise
+func (cm CommandManager) Reject(message discordgo.Message, reason string) (err error) {
+	source, err := cm.getCommandSource(message)
+	if err != nil {
+		return
+	}
+
+	if !(source == CommandSourcePRIVATE && cm.Conversations.Active(message.Author.ID)) {
+		content, ok := cm.stripPrefix(message.Content, source)
+		if !ok {
 			return
 		}
-	case CommandSourcePRIMARY:
-		if message.ChannelID != cm.App.config.PrimaryChannel {
+		if _, exists := cm.Commands[strings.ToLower(strings.SplitN(content, " ", 2)[0])]; !exists {
 			return
 		}
 	}
 
-	// Check if the user is verified.
-	// todo: write IsUserVerified based on roles rather than a DB
-	// if commandObject.RequireVerified {
-	// 	verified, err := cm.App.IsUserVerified(message.Author.ID)
-	// 	if err != nil {
-	// 		errs = append(errs, err)
-	// 		return exists, source, errs
-	// 	}
-	// 	if !verified {
-	// 		logger.Debug("ignoring command that requires verification from non-verified user", zap.String("command", commandTrigger))
-
-	// 		_, err = cm.App.discordClient.ChannelMessageSend(message.ChannelID, cm.App.locale.GetLangString("en", "CommandRequireVerification", message.Author.ID))
-	// 		if err != nil {
-	// 			errs = append(errs, err)
-	// 		}
-	// 		return exists, source, errs
-	// 	}
-	// }
+	_, err = cm.Session.ChannelMessageSend(message.ChannelID, reason)
+	return
+}
 
-	// Check if we have the required number of parameters.
-	if commandObject.ParametersRange.Minimum > -1 && commandParametersCount < commandObject.ParametersRange.Minimum {
-		logger.Debug("ignoring ignoring command with incorrect parameter count", zap.String("command", commandTrigger))
+// authorise checks that a command may be used from the message's source and by
+// its author, returning a message explaining why not if it can't
+func (cm CommandManager) authorise(command Command, trigger string, source CommandSource, message discordgo.Message) (denial string, err error) {
+	if !cm.Allowed(command, source, message.ChannelID) {
+		logger.Debug("ignoring command from wrong source",
+			zap.String("command", trigger),
+			zap.Stringer("source", source))
 
-		_, err = cm.App.discordClient.ChannelMessageSend(message.ChannelID,
-			fmt.Sprintf("%s\n%s\n%s", commandObject.Usage, commandObject.Description, commandObject.Example))
+		commandsProcessed.Inc(trigger, "denied")
+		return fmt.Sprintf("`%s` can only be used in %s.", trigger, cm.DescribeSources(command)), nil
+	}
 
+	// Check if the user has the roles the command requires.
+	denial, err = cm.checkPermissions(command, trigger, message.Author.ID)
+	if err != nil {
 		return
-	} else if commandObject.ParametersRange.Maximum > -1 && commandParametersCount > commandObject.ParametersRange.Maximum {
-		logger.Debug("ignoring ignoring command with incorrect parameter count", zap.String("command", commandTrigger))
+	}
+	if denial != "" {
+		commandsProcessed.Inc(trigger, "denied")
+		logger.Debug("ignoring command from user without permission",
+			zap.String("command", trigger),
+			zap.String("userID", message.Author.ID))
+	}
+	return
+}
+
+// run calls a command's function and replies with the command's help if the
+// function reports that it was used incorrectly
+func (cm CommandManager) run(ctx context.Context, command Command, trigger string, args Arguments, message discordgo.Message, contextual bool) (err error) {
+	outcome := "error"
+	defer func() {
+		commandsProcessed.Inc(trigger, outcome)
+		if err != nil {
+			err = &CommandError{Command: trigger, UserID: message.Author.ID, ChannelID: message.ChannelID, Err: err}
+		}
+	}()
 
-		_, err = cm.App.discordClient.ChannelMessageSend(message.ChannelID,
-			fmt.Sprintf("Too many parameters, command requires %d", commandObject.ParametersRange.Maximum))
+	if !cm.Limiter.Acquire(trigger, message.Author.ID, command.Concurrency) {
+		outcome = "busy"
+		_, err = cm.Session.ChannelMessageSend(message.ChannelID,
+			fmt.Sprintf("You already have a `%s` in progress, please wait for it to finish.", trigger))
+		return
+	}
+	if command.Concurrency > 0 {
+		defer cm.Limiter.Release(trigger, message.Author.ID)
+	}
 
+	if wait := cm.Limiter.Allow(trigger, message.Author.ID, command.Cooldown); wait > 0 {
+		outcome = "cooldown"
+		_, err = cm.Session.ChannelMessageSend(message.ChannelID,
+			fmt.Sprintf("You are using `%s` too often, try again in %s.", trigger, wait.Round(time.Second)))
 		return
 	}
 
-	err = cm.App.discordClient.ChannelTyping(message.ChannelID)
+	err = cm.Session.ChannelTyping(message.ChannelID)
 	if err != nil {
 		return
 	}
 
-	success, err := commandObject.Function(commandArgument, message, false)
+	// the job may have spent its time waiting in the queue, or the bot may be
+	// shutting down, in which case the command is not started at all
+	if ctx.Err() != nil {
+		outcome = "timeout"
+		err = errors.Wrap(ctx.Err(), "command not started")
+		return
+	}
+
+	success, err := command.Function(ctx, args, message, contextual)
 	if err != nil {
 		return
 	}
 
 	if !success {
-		_, err = cm.App.discordClient.ChannelMessageSend(
-			message.ChannelID,
-			fmt.Sprintf("%s\n%s\n%s", commandObject.Usage, commandObject.Description, commandObject.Example))
+		outcome = "usage"
+		_, err = cm.Session.ChannelMessageSend(message.ChannelID, command.Help(cm.App.Config().CommandPrefix, trigger))
 		return
 	}
 
+	outcome = "ok"
+
 	return
 }
 
+// Allowed returns true if a command may be used from the given source, commands
+// from other channels are only allowed in the OtherChannels allowlist
+func (cm CommandManager) Allowed(command Command, source CommandSource, channelID string) bool {
+	if source == CommandSourceOTHER {
+		allowlisted := false
+		for _, id := range cm.App.Config().OtherChannels {
+			if id == channelID {
+				allowlisted = true
+			}
+		}
+		if !allowlisted {
+			return false
+		}
+	}
+
+	for _, allowed := range command.Sources {
+		if allowed == source {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeSources lists the places a command can be used in a human readable
+// form, with channels as mentions
+func (cm CommandManager) DescribeSources(command Command) string {
+	var places []string
+	for _, source := range command.Sources {
+		switch source {
+		case CommandSourceADMINISTRATIVE:
+			places = append(places, "<#"+cm.App.Config().AdministrativeChannel+">")
+		case CommandSourcePRIMARY:
+			places = append(places, "<#"+cm.App.Config().PrimaryChannel+">")
+		case CommandSourcePRIVATE:
+			places = append(places, "direct messages")
+		case CommandSourceOTHER:
+			for _, id := range cm.App.Config().OtherChannels {
+				places = append(places, "<#"+id+">")
+			}
+		}
+	}
+
+	switch len(places) {
+	case 0:
+		return "no channels"
+	case 1:
+		return places[0]
+	}
+	return strings.Join(places[:len(places)-1], ", ") + " or " + places[len(places)-1]
+}
+
+// stripPrefix removes the command prefix or a mention of the bot from the start
+// of a message. Messages without either are only commands in direct messages.
+func (cm CommandManager) stripPrefix(content string, source CommandSource) (string, bool) {
+	content = strings.TrimSpace(content)
+
+	prefixes := []string{
+		"<@" + cm.App.Config().BotID + ">",
+		"<@!" + cm.App.Config().BotID + ">",
+	}
+	if cm.App.Config().CommandPrefix != "" {
+		prefixes = append(prefixes, cm.App.Config().CommandPrefix)
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(content, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(content, prefix)), true
+		}
+	}
+
+	return content, source == CommandSourcePRIVATE
+}
+
 func (cm CommandManager) getCommandSource(message discordgo.Message) (CommandSource, error) {
-	if message.ChannelID == cm.App.config.AdministrativeChannel {
+	if message.ChannelID == cm.App.Config().AdministrativeChannel {
 		return CommandSourceADMINISTRATIVE, nil
-	} else if message.ChannelID == cm.App.config.PrimaryChannel {
+	} else if message.ChannelID == cm.App.Config().PrimaryChannel {
 		return CommandSourcePRIMARY, nil
 	} else {
-		ch, err := cm.App.discordClient.Channel(message.ChannelID)
+		ch, err := cm.Session.Channel(message.ChannelID)
 		if err != nil {
 			return CommandSourceOTHER, err
 		}
@@ -210,3 +461,62 @@ func (cm CommandManager) getCommandSource(message discordgo.Message) (CommandSou
 
 	return CommandSourceOTHER, nil
 }
+
+// checkPermissions returns a message explaining why the user may not run the
+// command, or an empty string if they may
+func (cm CommandManager) checkPermissions(command Command, trigger, userID string) (denial string, err error) {
+	if !command.RequireVerified && !command.RequireAdmin {
+		return
+	}
+
+	roles, err := cm.memberRoles(userID)
+	if err != nil {
+		return
+	}
+	return cm.rolesDenial(command, trigger, roles), nil
+}
+
+// memberRoles returns the set of roles a user has in the guild, or nil if they
+// are not a member of it
+func (cm CommandManager) memberRoles(userID string) (roles map[string]bool, err error) {
+	member, err := cm.Session.GuildMember(cm.App.Config().GuildID, userID)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get guild member")
+	}
+
+	roles = make(map[string]bool)
+	for _, role := range member.Roles {
+		roles[role] = true
+	}
+	return
+}
+
+// rolesDenial checks a set of roles from memberRoles against the roles a
+// command requires, returning why the command can't be used if it can't
+func (cm CommandManager) rolesDenial(command Command, trigger string, roles map[string]bool) string {
+	if !command.RequireVerified && !command.RequireAdmin {
+		return ""
+	}
+
+	if roles == nil {
+		return "You must be a member of the Bay Area Roleplay Discord server to use this command."
+	}
+
+	if command.RequireVerified && !roles[cm.App.Config().VerifiedRole] {
+		return fmt.Sprintf("You must verify your forum account before you can use `%s`, send me `verify <profile page URL>` in a direct message to get started.", trigger)
+	}
+
+	if command.RequireAdmin {
+		for _, role := range cm.App.Config().AdminRoles {
+			if roles[role] {
+				return ""
+			}
+		}
+		return fmt.Sprintf("You must be an administrator to use `%s`.", trigger)
+	}
+
+	return ""
+}
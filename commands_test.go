@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/patrickmn/go-cache"
+)
+
+// fakeSession is a Discord session with a fixed set of channels and guild
+// members that records every message sent through it
+type fakeSession struct {
+	channels map[string]*discordgo.Channel
+	members  map[string]*discordgo.Member
+	failing  bool // whether GuildMember fails with a server error
+	sent     []string
+}
+
+func (s *fakeSession) Channel(channelID string) (*discordgo.Channel, error) {
+	if channel, ok := s.channels[channelID]; ok {
+		return channel, nil
+	}
+	return nil, restError(http.StatusNotFound)
+}
+
+func (s *fakeSession) ChannelMessageSend(channelID string, content string) (*discordgo.Message, error) {
+	s.sent = append(s.sent, content)
+	return &discordgo.Message{ChannelID: channelID, Content: content}, nil
+}
+
+func (s *fakeSession) ChannelTyping(channelID string) error {
+	return nil
+}
+
+func (s *fakeSession) GuildMember(guildID, userID string) (*discordgo.Member, error) {
+	if s.failing {
+		return nil, restError(http.StatusInternalServerError)
+	}
+	if member, ok := s.members[userID]; ok && guildID == "guild" {
+		return member, nil
+	}
+	return nil, restError(http.StatusNotFound)
+}
+
+func restError(status int) error {
+	return &discordgo.RESTError{Response: &http.Response{StatusCode: status, Status: http.StatusText(status)}}
+}
+
+// newTestCommandManager creates a command manager with three commands, open to
+// anyone, for verified members and for admins, that count how often they run
+func newTestCommandManager(session Session, ran map[string]int) CommandManager {
+	app := &App{
+		liveConfig: &atomic.Value{},
+		cache:      cache.New(time.Minute, time.Minute),
+	}
+	app.liveConfig.Store(Config{
+		BotID:                 "bot",
+		GuildID:               "guild",
+		AdministrativeChannel: "admin",
+		PrimaryChannel:        "primary",
+		VerifiedRole:          "verified",
+		AdminRoles:            []string{"staff"},
+		CommandPrefix:         "!",
+	})
+
+	function := func(name string) func(context.Context, Arguments, discordgo.Message, bool) (bool, error) {
+		return func(ctx context.Context, args Arguments, message discordgo.Message, contextual bool) (bool, error) {
+			ran[name]++
+			return true, nil
+		}
+	}
+
+	cm := CommandManager{
+		App:     app,
+		Session: session,
+		Commands: map[string]Command{
+			"open": {
+				Function: function("open"),
+				Sources:  []CommandSource{CommandSourcePRIVATE, CommandSourcePRIMARY},
+			},
+			"verified": {
+				Function:        function("verified"),
+				Sources:         []CommandSource{CommandSourcePRIVATE, CommandSourcePRIMARY},
+				RequireVerified: true,
+			},
+			"admin": {
+				Function:     function("admin"),
+				Sources:      []CommandSource{CommandSourceADMINISTRATIVE},
+				RequireAdmin: true,
+			},
+		},
+		Conversations: NewConversationManager(app, time.Minute),
+		Limiter:       NewLimiter(app.cache),
+	}
+	cm.Conversations.Session = session
+	return cm
+}
+
+func TestProcess(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		channel   string
+		author    string
+		content   string
+		failing   bool
+		wantExist bool
+		wantErr   bool
+		wantRun   string
+		wantReply string
+	}{
+		{
+			name: "open command in DM", channel: "dm", author: "stranger", content: "open",
+			wantExist: true, wantRun: "open",
+		},
+		{
+			name: "open command without prefix in primary", channel: "primary", author: "stranger", content: "open",
+		},
+		{
+			name: "unknown command", channel: "primary", author: "member", content: "!nothing",
+		},
+		{
+			name: "verified command by verified member", channel: "primary", author: "member", content: "!verified",
+			wantExist: true, wantRun: "verified",
+		},
+		{
+			name: "verified command by unverified member", channel: "dm", author: "unverified", content: "verified",
+			wantExist: true,
+			wantReply: "You must verify your forum account before you can use `verified`, send me `verify <profile page URL>` in a direct message to get started.",
+		},
+		{
+			name: "verified command by someone not in the guild", channel: "dm", author: "stranger", content: "verified",
+			wantExist: true,
+			wantReply: "You must be a member of the Bay Area Roleplay Discord server to use this command.",
+		},
+		{
+			name: "verified command when Discord fails", channel: "dm", author: "member", content: "verified", failing: true,
+			wantExist: true, wantErr: true,
+		},
+		{
+			name: "admin command by admin", channel: "admin", author: "admin", content: "!admin",
+			wantExist: true, wantRun: "admin",
+		},
+		{
+			name: "admin command by verified member", channel: "admin", author: "member", content: "!admin",
+			wantExist: true,
+			wantReply: "You must be an administrator to use `admin`.",
+		},
+		{
+			name: "admin command by someone not in the guild", channel: "admin", author: "stranger", content: "!admin",
+			wantExist: true,
+			wantReply: "You must be a member of the Bay Area Roleplay Discord server to use this command.",
+		},
+		{
+			name: "admin command in the wrong channel", channel: "primary", author: "admin", content: "!admin",
+			wantExist: true,
+			wantReply: "`admin` can only be used in <#admin>.",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &fakeSession{
+				channels: map[string]*discordgo.Channel{
+					"dm": {ID: "dm", Type: discordgo.ChannelTypeDM},
+				},
+				members: map[string]*discordgo.Member{
+					"unverified": {Roles: []string{}},
+					"member":     {Roles: []string{"verified"}},
+					"admin":      {Roles: []string{"verified", "staff"}},
+				},
+				failing: tt.failing,
+			}
+			ran := make(map[string]int)
+			cm := newTestCommandManager(session, ran)
+
+			exists, _, err := cm.Process(context.Background(), discordgo.Message{
+				ChannelID: tt.channel,
+				Content:   tt.content,
+				Author:    &discordgo.User{ID: tt.author},
+			})
+
+			if exists != tt.wantExist {
+				t.Errorf("exists = %v, want %v", exists, tt.wantExist)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %v", err, tt.wantErr)
+			}
+
+			for name, count := range ran {
+				if name != tt.wantRun || count != 1 {
+					t.Errorf("%s ran %d times", name, count)
+				}
+			}
+			if tt.wantRun != "" && ran[tt.wantRun] == 0 {
+				t.Errorf("%s did not run", tt.wantRun)
+			}
+
+			switch {
+			case tt.wantReply == "" && len(session.sent) > 0:
+				t.Errorf("sent %q, want nothing", session.sent)
+			case tt.wantReply != "" && (len(session.sent) != 1 || session.sent[0] != tt.wantReply):
+				t.Errorf("sent %q, want %q", session.sent, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestProcessCancelled(t *testing.T) {
+	session := &fakeSession{}
+	ran := make(map[string]int)
+	cm := newTestCommandManager(session, ran)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := cm.Process(ctx, discordgo.Message{
+		ChannelID: "primary",
+		Content:   "!open",
+		Author:    &discordgo.User{ID: "member"},
+	})
+	if err == nil {
+		t.Error("cancelled command did not return an error")
+	}
+	if ran["open"] != 0 {
+		t.Error("cancelled command ran")
+	}
+}
+
+func TestReject(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		channel   string
+		content   string
+		wantReply bool
+	}{
+		{"command in DM", "dm", "open", true},
+		{"command with prefix in primary", "primary", "!open now", true},
+		{"chat in primary", "primary", "hello everyone", false},
+		{"unknown command", "primary", "!nothing", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &fakeSession{
+				channels: map[string]*discordgo.Channel{
+					"dm": {ID: "dm", Type: discordgo.ChannelTypeDM},
+				},
+			}
+			cm := newTestCommandManager(session, make(map[string]int))
+
+			err := cm.Reject(discordgo.Message{
+				ChannelID: tt.channel,
+				Content:   tt.content,
+				Author:    &discordgo.User{ID: "member"},
+			}, "not now")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if replied := len(session.sent) == 1 && session.sent[0] == "not now"; replied != tt.wantReply {
+				t.Errorf("sent %q, want reply: %v", session.sent, tt.wantReply)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MatchID matches Discord and forum IDs, which are always numeric
+var MatchID = regexp.MustCompile(`^[0-9]+$`)
+
+// ConfigProblem is a config setting that is missing or invalid
+type ConfigProblem struct {
+	Setting string
+	Problem string
+}
+
+// LoadConfig reads the config from the environment, including secrets from
+// MACCER_*_FILE, and if MACCER_CONFIG_FILE is set layers the YAML config file
+// over it
+func LoadConfig() (config Config, err error) {
+	err = envconfig.Process("maccer", &config)
+	if err != nil {
+		err = errors.Wrap(err, "failed to read environment")
+		return
+	}
+
+	err = loadSecretFiles(&config)
+	if err != nil {
+		return
+	}
+
+	if config.ConfigFile == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(config.ConfigFile)
+	if err != nil {
+		err = errors.Wrap(err, "failed to read config file")
+		return
+	}
+
+	// maps are merged by the decoder, so clear any the file sets to make
+	// them replace the environment like every other setting
+	var present map[string]interface{}
+	err = yaml.Unmarshal(data, &present)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse config file")
+		return
+	}
+	value := reflect.ValueOf(&config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if _, ok := present[yamlName(value.Type().Field(i))]; ok && value.Field(i).Kind() == reflect.Map {
+			value.Field(i).Set(reflect.Zero(value.Field(i).Type()))
+		}
+	}
+
+	err = yaml.UnmarshalStrict(data, &config)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse config file")
+	}
+	return
+}
+
+// Validate checks every setting and returns the problems found, if any
+func (config Config) Validate() (problems []ConfigProblem) {
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		rules := strings.Split(field.Tag.Get("validate"), ",")
+
+		for _, rule := range rules {
+			var problem string
+			switch rule {
+			case "required":
+				if isZero(value.Field(i)) {
+					problem = "is required"
+				}
+			case "id":
+				for _, id := range stringValues(value.Field(i)) {
+					if !MatchID.MatchString(id) {
+						problem = "must be a numeric ID, got '" + id + "'"
+					}
+				}
+			}
+			if problem != "" {
+				problems = append(problems, ConfigProblem{yamlName(field), problem})
+			}
+		}
+	}
+
+	for group, role := range config.GroupRoles {
+		if !MatchID.MatchString(group) || !MatchID.MatchString(role) {
+			problems = append(problems, ConfigProblem{"group_roles", "must map numeric IDs, got '" + group + ":" + role + "'"})
+		}
+	}
+
+	if config.ForumEndpoint != "" {
+		endpoint, err := url.Parse(config.ForumEndpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			problems = append(problems, ConfigProblem{"forum_endpoint", "must be an http or https URL"})
+		}
+	}
+
+	switch config.Store {
+	case "mongo", "":
+		for _, setting := range []struct{ name, value string }{
+			{"mongo_host", config.MongoHost},
+			{"mongo_port", config.MongoPort},
+			{"mongo_name", config.MongoName},
+		} {
+			if setting.value == "" {
+				problems = append(problems, ConfigProblem{setting.name, "is required by the mongo store"})
+			}
+		}
+	case "file":
+		if config.StorePath == "" {
+			problems = append(problems, ConfigProblem{"store_path", "is required by the file store"})
+		}
+	case "memory":
+	default:
+		problems = append(problems, ConfigProblem{"store", "must be mongo, file or memory"})
+	}
+
+	if config.InteractionsAddress != "" && config.DiscordPublicKey == "" {
+		problems = append(problems, ConfigProblem{"discord_public_key", "is required when interactions_address is set"})
+	}
+	if config.CommandPrefix == "" {
+		problems = append(problems, ConfigProblem{"command_prefix", "must not be empty"})
+	}
+	if config.Workers < 1 {
+		problems = append(problems, ConfigProblem{"workers", "must be at least 1"})
+	}
+	if config.WorkerQueue < 1 {
+		problems = append(problems, ConfigProblem{"worker_queue", "must be at least 1"})
+	}
+
+	return
+}
+
+// ReportConfig logs where the config was loaded from and every problem with it,
+// returning false if there were any problems
+func ReportConfig(config Config) bool {
+	source := "environment"
+	if config.ConfigFile != "" {
+		source = "environment and " + config.ConfigFile
+	}
+
+	problems := config.Validate()
+	for _, problem := range problems {
+		logger.Error("config problem",
+			zap.String("setting", problem.Setting),
+			zap.String("env", "MACCER_"+strings.ToUpper(problem.Setting)),
+			zap.String("problem", problem.Problem))
+	}
+
+	logger.Info("config validated",
+		zap.String("source", source),
+		zap.Int("problems", len(problems)))
+
+	return len(problems) == 0
+}
+
+// Config returns the current config, it may change when the config file is
+// reloaded so callers should not hold on to it
+func (app *App) Config() Config {
+	return app.liveConfig.Load().(Config)
+}
+
+// ReloadConfig loads the config again and applies the settings that can change
+// at runtime, an invalid config is rejected and the current one kept
+func (app *App) ReloadConfig() (err error) {
+	next, err := LoadConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to reload config")
+	}
+
+	if !ReportConfig(next) {
+		return errors.New("config reload rejected, see the logged problems")
+	}
+
+	merged, changed, ignored := applyReload(app.Config(), next)
+	for _, setting := range ignored {
+		logger.Warn("config setting changed but needs a restart to apply",
+			zap.String("setting", setting))
+	}
+
+	app.liveConfig.Store(merged)
+
+	logger.Info("config reloaded",
+		zap.Strings("changed", changed))
+	return
+}
+
+// WatchConfig reloads the config on SIGHUP or when the config file changes, until
+// ctx is done
+func (app *App) WatchConfig(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	path := app.Config().ConfigFile
+	modified := modTime(path)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hangup:
+			if path == "" {
+				logger.Info("received SIGHUP but there is no config file to reload")
+				continue
+			}
+
+		case <-ticker.C:
+			if path == "" || !modTime(path).After(modified) {
+				continue
+			}
+		}
+
+		modified = modTime(path)
+
+		err := app.ReloadConfig()
+		if err != nil {
+			app.ChannelLogError(err)
+		}
+	}
+}
+
+// applyReload takes the reloadable settings from next and keeps the rest from
+// current, returning the settings that changed and those that were ignored
+func applyReload(current, next Config) (merged Config, changed, ignored []string) {
+	merged = current
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	currentValue := reflect.ValueOf(current)
+	nextValue := reflect.ValueOf(next)
+
+	for i := 0; i < currentValue.NumField(); i++ {
+		if reflect.DeepEqual(currentValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+
+		field := currentValue.Type().Field(i)
+		if field.Tag.Get("reload") == "true" {
+			mergedValue.Field(i).Set(nextValue.Field(i))
+			changed = append(changed, yamlName(field))
+		} else {
+			ignored = append(ignored, yamlName(field))
+		}
+	}
+	return
+}
+
+func yamlName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+func isZero(value reflect.Value) bool {
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
+
+// stringValues returns the non-empty strings in a string or string slice field
+func stringValues(value reflect.Value) (values []string) {
+	switch value.Kind() {
+	case reflect.String:
+		if value.String() != "" {
+			values = append(values, value.String())
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			values = append(values, stringValues(value.Index(i))...)
+		}
+	}
+	return
+}
+
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExampleConfig(t *testing.T) {
+	for name, value := range map[string]string{
+		"MACCER_CONFIG_FILE":   "config.example.yaml",
+		"MACCER_DISCORD_TOKEN": "token",
+		"MACCER_FORUM_KEY":     "key",
+	} {
+		os.Setenv(name, value)  // nolint:errcheck
+		defer os.Unsetenv(name) // nolint:errcheck
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := config.Validate(); len(problems) > 0 {
+		t.Errorf("example config has problems: %v", problems)
+	}
+	if config.ReconcileInterval.Hours() != 1 || len(config.GroupRoles) != 2 {
+		t.Errorf("example config was not read: %+v", config)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// Conversation is a sequence of questions asked to a user in direct messages,
+// each answer is parsed like a command argument and the answers are passed to
+// Complete once every step has been answered.
+type Conversation struct {
+	Steps    []Argument
+	Answers  Arguments
+	Complete func(ctx context.Context, answers Arguments, message discordgo.Message) error
+}
+
+// ConversationManager tracks the active conversation of each user
+type ConversationManager struct {
+	App     *App
+	Session Session
+	Timeout time.Duration
+
+	lock   sync.Mutex
+	active map[string]*conversationState
+}
+
+type conversationState struct {
+	conversation Conversation
+	step         int
+	channelID    string
+	timer        *time.Timer
+}
+
+// NewConversationManager creates a conversation manager with no conversations
+func NewConversationManager(app *App, timeout time.Duration) *ConversationManager {
+	return &ConversationManager{
+		App:     app,
+		Timeout: timeout,
+		active:  make(map[string]*conversationState),
+	}
+}
+
+// Active returns true if the user is currently in a conversation
+func (cvm *ConversationManager) Active(userID string) bool {
+	cvm.lock.Lock()
+	defer cvm.lock.Unlock()
+
+	_, ok := cvm.active[userID]
+	return ok
+}
+
+// Start begins a conversation with a user in the given channel, replacing any
+// conversation they were already in, and asks the first question
+func (cvm *ConversationManager) Start(userID, channelID string, conversation Conversation) (err error) {
+	if conversation.Answers == nil {
+		conversation.Answers = make(Arguments)
+	}
+
+	state := &conversationState{
+		conversation: conversation,
+		channelID:    channelID,
+	}
+	state.timer = time.AfterFunc(cvm.Timeout, func() {
+		cvm.expire(userID, state)
+	})
+
+	cvm.lock.Lock()
+	if previous, ok := cvm.active[userID]; ok {
+		previous.timer.Stop()
+	}
+	cvm.active[userID] = state
+	cvm.lock.Unlock()
+
+	logger.Debug("conversation started",
+		zap.String("userID", userID),
+		zap.Int("steps", len(conversation.Steps)))
+
+	return cvm.ask(state)
+}
+
+// Cancel ends a user's conversation without completing it
+func (cvm *ConversationManager) Cancel(userID string) bool {
+	cvm.lock.Lock()
+	defer cvm.lock.Unlock()
+
+	state, ok := cvm.active[userID]
+	if !ok {
+		return false
+	}
+	state.timer.Stop()
+	delete(cvm.active, userID)
+	return true
+}
+
+// Handle passes a message to the author's conversation as the answer to the
+// current question, moving on to the next question or completing it
+func (cvm *ConversationManager) Handle(ctx context.Context, message discordgo.Message) (err error) {
+	userID := message.Author.ID
+	answer := strings.TrimSpace(message.Content)
+
+	if strings.EqualFold(strings.TrimPrefix(answer, cvm.App.Config().CommandPrefix), "cancel") {
+		if cvm.Cancel(userID) {
+			_, err = cvm.Session.ChannelMessageSend(message.ChannelID, "Okay, cancelled.")
+		}
+		return
+	}
+
+	cvm.lock.Lock()
+	state, ok := cvm.active[userID]
+	if !ok {
+		cvm.lock.Unlock()
+		return
+	}
+
+	step := state.conversation.Steps[state.step]
+	if step.Type == ArgumentRest {
+		state.conversation.Answers[step.Name] = answer
+	} else {
+		value, valid := parseValue(step.Type, answer)
+		if !valid {
+			cvm.lock.Unlock()
+			_, err = cvm.Session.ChannelMessageSend(message.ChannelID,
+				fmt.Sprintf("Sorry, %s. Try again or say `cancel` to stop.", ArgumentError{Argument: &step, Value: answer}))
+			return
+		}
+		state.conversation.Answers[step.Name] = value
+	}
+
+	state.step++
+	state.timer.Reset(cvm.Timeout)
+
+	if state.step < len(state.conversation.Steps) {
+		cvm.lock.Unlock()
+		return cvm.ask(state)
+	}
+
+	state.timer.Stop()
+	delete(cvm.active, userID)
+	cvm.lock.Unlock()
+
+	logger.Debug("conversation complete",
+		zap.String("userID", userID))
+
+	return state.conversation.Complete(ctx, state.conversation.Answers, message)
+}
+
+func (cvm *ConversationManager) ask(state *conversationState) (err error) {
+	step := state.conversation.Steps[state.step]
+
+	prompt := step.Prompt
+	if prompt == "" {
+		prompt = fmt.Sprintf("What is the %s?", step.Name)
+	}
+
+	_, err = cvm.Session.ChannelMessageSend(state.channelID, prompt)
+	return
+}
+
+func (cvm *ConversationManager) expire(userID string, state *conversationState) {
+	cvm.lock.Lock()
+	current, ok := cvm.active[userID]
+	if !ok || current != state {
+		cvm.lock.Unlock()
+		return
+	}
+	delete(cvm.active, userID)
+	cvm.lock.Unlock()
+
+	logger.Debug("conversation expired",
+		zap.String("userID", userID))
+
+	_, err := cvm.Session.ChannelMessageSend(state.channelID, "You took too long to answer, please start again.")
+	if err != nil {
+		cvm.App.ChannelLogError(err)
+	}
+}
@@ -1,115 +1,139 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/Southclaws/invision-community-go"
 	"github.com/bwmarrin/discordgo"
 	"github.com/patrickmn/go-cache"
 	"go.uber.org/zap"
-	mgo "gopkg.in/mgo.v2"
 )
 
 // App stores program state
 type App struct {
-	config         Config
+	Store
+
+	liveConfig     *atomic.Value
 	discordClient  *discordgo.Session
-	mongodb        *mgo.Session
-	users          *mgo.Collection
-	ipsClient      *ips.Client
-	ready          chan bool
 	cache          *cache.Cache
 	commandManager *CommandManager
+	executor       *Executor
+	reporter       *ErrorReporter
+	interactions   *http.Server
+	status         *http.Server
+	lifecycle      *Lifecycle
+	ctx            context.Context
+	cancel         context.CancelFunc
+	background     *sync.WaitGroup
 }
 
-// Start starts the app with the specified config and blocks until fatal error
+// Start starts the app with the specified config and blocks until it is
+// interrupted or terminated, at which point it shuts down gracefully
 func Start(config Config) {
 	var err error
 
 	app := App{
-		config: config,
-		cache:  cache.New(5*time.Minute, 30*time.Second),
+		liveConfig: &atomic.Value{},
+		lifecycle:  NewLifecycle(),
+		cache:      cache.New(5*time.Minute, 30*time.Second),
+		background: &sync.WaitGroup{},
 	}
+	app.liveConfig.Store(config)
+	app.reporter = NewErrorReporter(&app)
+	app.ctx, app.cancel = context.WithCancel(context.Background())
 
-	app.mongodb, err = mgo.Dial(fmt.Sprintf("%s:%s", config.MongoHost, config.MongoPort))
+	app.Store, err = NewStore(config)
 	if err != nil {
-		logger.Fatal("failed to connect to database",
+		logger.Fatal("failed to open store",
 			zap.Error(err))
 	}
 
-	if config.MongoPass != "" {
-		err = app.mongodb.Login(&mgo.Credential{
-			Source:   config.MongoName,
-			Username: config.MongoUser,
-			Password: config.MongoPass,
-		})
+	logger.Debug("started with debug logging enabled",
+		zap.Any("config", app.Config()))
+
+	app.executor = NewExecutor(config.Workers, config.WorkerQueue, config.CommandTimeout, app.ChannelLogError)
+	app.StartStatus()
+	app.StartCommandManager()
+	app.ConnectDiscord()
+	app.Go(app.reporter.Run)
+	app.ResumeVerifications()
+	app.Go(app.StartReconciler)
+	app.Go(app.WatchConfig)
+	app.StartInteractions()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	logger.Info("shutting down",
+		zap.Stringer("signal", sig))
+
+	app.Shutdown()
+}
+
+// Shutdown stops accepting commands, waits up to ShutdownTimeout for running
+// commands and background tasks to finish, then closes all connections. Pending
+// verifications stay in the database and are resumed on the next start.
+func (app *App) Shutdown() {
+	app.lifecycle.Set(StateStopping, "shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), app.Config().ShutdownTimeout)
+	defer cancel()
+
+	if app.interactions != nil {
+		err := app.interactions.Shutdown(ctx)
 		if err != nil {
-			logger.Fatal("failed to authenticate to database",
-				zap.Error(err))
+			logger.Warn("failed to stop interactions endpoint", zap.Error(err))
 		}
 	}
 
-	exists, err := app.CollectionExists(config.MongoName, "users")
+	err := app.executor.Stop(ctx)
 	if err != nil {
-		logger.Fatal("failed to check collection", zap.Error(err))
+		logger.Warn("commands did not finish in time", zap.Error(err))
 	}
-	if !exists {
-		err = app.mongodb.DB(config.MongoName).C("users").Create(&mgo.CollectionInfo{})
-		if err != nil {
-			logger.Fatal("failedto create collection", zap.Error(err))
-		}
-	}
-	app.users = app.mongodb.DB(config.MongoName).C("users")
 
-	err = app.users.EnsureIndex(mgo.Index{
-		Name:   "UNIQUE_DISCORD",
-		Key:    []string{"discord_id"},
-		Unique: true,
-	})
-	if err != nil {
-		logger.Fatal("failed to ensure index",
-			zap.Error(err))
+	app.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		app.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn("background tasks did not finish in time")
 	}
 
-	err = app.users.EnsureIndex(mgo.Index{
-		Name:   "UNIQUE_FORUM",
-		Key:    []string{"forum_id"},
-		Unique: true,
-	})
-	if err != nil {
-		logger.Fatal("failed to ensure index",
-			zap.Error(err))
+	if app.status != nil {
+		err = app.status.Shutdown(ctx)
+		if err != nil {
+			logger.Warn("failed to stop status endpoint", zap.Error(err))
+		}
 	}
 
-	app.ipsClient, err = ips.NewClient(config.ForumEndpoint, config.ForumKey)
+	err = app.discordClient.Close()
 	if err != nil {
-		logger.Fatal("failed to create IPS client",
-			zap.Error(err))
+		logger.Warn("failed to close Discord connection", zap.Error(err))
 	}
 
-	logger.Debug("started with debug logging enabled",
-		zap.Any("config", app.config))
-
-	app.StartCommandManager()
-	app.ConnectDiscord()
+	app.Store.Close()
 
-	done := make(chan bool)
-	<-done
+	logger.Info("shutdown complete")
 }
 
-// CollectionExists checks if a collection exists in MongoDB
-func (app App) CollectionExists(db, wantCollection string) (bool, error) {
-	collections, err := app.mongodb.DB(db).CollectionNames()
-	if err != nil {
-		return false, err
-	}
-
-	for _, collection := range collections {
-		if collection == wantCollection {
-			return true, nil
-		}
-	}
-
-	return false, nil
+// Go runs a background task that is stopped and waited for during shutdown,
+// the task must return once ctx is done
+func (app *App) Go(task func(ctx context.Context)) {
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		task(app.ctx)
+	}()
 }
@@ -1,28 +1,149 @@
 package main
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/Southclaws/maccer/types"
 	"github.com/pkg/errors"
+	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-var (
-	// ErrUserDiscordDuplicate is triggered when a discord ID is attempted to be registered twice
-	ErrUserDiscordDuplicate = errors.New("discord ID already registered")
-	// ErrUserForumDuplicate is triggered when a forum ID is attempted to be registered twice
-	ErrUserForumDuplicate = errors.New("forum ID already registered")
-)
+// MongoStore stores everything in a MongoDB database
+type MongoStore struct {
+	session       *mgo.Session
+	users         *mgo.Collection
+	verifications *mgo.Collection
+	audit         *mgo.Collection
+}
+
+// NewMongoStore connects to MongoDB and ensures the collections and their
+// indexes exist
+func NewMongoStore(config Config) (db *MongoStore, err error) {
+	if config.MongoHost == "" || config.MongoPort == "" || config.MongoName == "" {
+		return nil, errors.New("MongoHost, MongoPort and MongoName must be set to use the mongo store")
+	}
+
+	db = &MongoStore{}
+
+	db.session, err = mgo.Dial(fmt.Sprintf("%s:%s", config.MongoHost, config.MongoPort))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to database")
+	}
+
+	if config.MongoPass != "" {
+		err = db.session.Login(&mgo.Credential{
+			Source:   config.MongoName,
+			Username: config.MongoUser,
+			Password: config.MongoPass,
+		})
+		if err != nil {
+			db.session.Close()
+			return nil, errors.Wrap(err, "failed to authenticate to database")
+		}
+	}
+
+	err = db.ensure(config.MongoName)
+	if err != nil {
+		db.session.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *MongoStore) ensure(name string) (err error) {
+	db.users, err = db.EnsureCollection(name, "users")
+	if err != nil {
+		return
+	}
+
+	err = db.users.EnsureIndex(mgo.Index{
+		Name:   "UNIQUE_DISCORD",
+		Key:    []string{"discord_id"},
+		Unique: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure index")
+	}
+
+	err = db.users.EnsureIndex(mgo.Index{
+		Name:   "UNIQUE_FORUM",
+		Key:    []string{"forum_id"},
+		Unique: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure index")
+	}
+
+	db.verifications, err = db.EnsureCollection(name, "verifications")
+	if err != nil {
+		return
+	}
+
+	err = db.verifications.EnsureIndex(mgo.Index{
+		Name:   "UNIQUE_DISCORD",
+		Key:    []string{"discord_id"},
+		Unique: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure index")
+	}
+
+	db.audit, err = db.EnsureCollection(name, "audit")
+	return
+}
+
+// CollectionExists checks if a collection exists in MongoDB
+func (db *MongoStore) CollectionExists(database, wantCollection string) (bool, error) {
+	collections, err := db.session.DB(database).CollectionNames()
+	if err != nil {
+		return false, err
+	}
+
+	for _, collection := range collections {
+		if collection == wantCollection {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// EnsureCollection returns a collection from MongoDB, creating it first if it
+// does not exist yet
+func (db *MongoStore) EnsureCollection(database, name string) (*mgo.Collection, error) {
+	exists, err := db.CollectionExists(database, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check collection")
+	}
+	if !exists {
+		err = db.session.DB(database).C(name).Create(&mgo.CollectionInfo{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create collection")
+		}
+	}
+	return db.session.DB(database).C(name), nil
+}
+
+// Ping checks the connection to MongoDB
+func (db *MongoStore) Ping() error {
+	return db.session.Ping()
+}
+
+// Close closes the connection to MongoDB
+func (db *MongoStore) Close() {
+	db.session.Close()
+}
 
 // CreateUser inserts a new record for a user
-func (app App) CreateUser(user types.User) (err error) {
-	err = app.users.Insert(user)
+func (db *MongoStore) CreateUser(user types.User) (err error) {
+	err = db.users.Insert(user)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE_DISCORD") {
 			err = ErrUserDiscordDuplicate
-		}
-		if strings.Contains(err.Error(), "UNIQUE_DISCORD") {
+		} else if strings.Contains(err.Error(), "UNIQUE_FORUM") {
 			err = ErrUserForumDuplicate
 		}
 	}
@@ -30,8 +151,8 @@ func (app App) CreateUser(user types.User) (err error) {
 }
 
 // GetUserByDiscord returns a user from the database via their discord ID
-func (app App) GetUserByDiscord(id string) (user types.User, exists bool, err error) {
-	err = app.users.Find(bson.M{"discord_id": id}).One(&user)
+func (db *MongoStore) GetUserByDiscord(id string) (user types.User, exists bool, err error) {
+	err = db.users.Find(bson.M{"discord_id": id}).One(&user)
 	if err != nil {
 		if err.Error() == "not found" {
 			err = nil
@@ -45,8 +166,8 @@ func (app App) GetUserByDiscord(id string) (user types.User, exists bool, err er
 }
 
 // GetUserByForum returns a user from the database via their forum ID
-func (app App) GetUserByForum(id string) (user types.User, exists bool, err error) {
-	err = app.users.Find(bson.M{"forum_id": id}).One(&user)
+func (db *MongoStore) GetUserByForum(id string) (user types.User, exists bool, err error) {
+	err = db.users.Find(bson.M{"forum_id": id}).One(&user)
 	if err != nil {
 		if err.Error() == "not found" {
 			err = nil
@@ -59,8 +180,103 @@ func (app App) GetUserByForum(id string) (user types.User, exists bool, err erro
 	return
 }
 
+// GetUsers returns every linked user in the database
+func (db *MongoStore) GetUsers() (users []types.User, err error) {
+	err = db.users.Find(nil).All(&users)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get users")
+	}
+	return
+}
+
 // UpdateUser updates the details for a user in the database
-func (app App) UpdateUser(user types.User) (err error) {
-	err = app.users.Update(bson.M{"discord_id": user.DiscordID}, user)
+func (db *MongoStore) UpdateUser(user types.User) (err error) {
+	err = db.users.Update(bson.M{"discord_id": user.DiscordID}, user)
+	if err != nil && err.Error() == "not found" {
+		err = ErrUserNotFound
+	}
+	return
+}
+
+// ReplaceUser atomically swaps an existing link for a new one, failing with
+// ErrUserLinkChanged if the existing link no longer matches `old`
+func (db *MongoStore) ReplaceUser(old, replacement types.User) (err error) {
+	err = db.users.Update(bson.M{"discord_id": old.DiscordID, "forum_id": old.ForumID}, replacement)
+	if err != nil {
+		if err.Error() == "not found" {
+			err = ErrUserLinkChanged
+		} else if strings.Contains(err.Error(), "UNIQUE_DISCORD") {
+			err = ErrUserDiscordDuplicate
+		} else if strings.Contains(err.Error(), "UNIQUE_FORUM") {
+			err = ErrUserForumDuplicate
+		}
+	}
+	return
+}
+
+// DeleteUser removes the link for a Discord user
+func (db *MongoStore) DeleteUser(discordID string) (err error) {
+	err = db.users.Remove(bson.M{"discord_id": discordID})
+	if err != nil {
+		if err.Error() == "not found" {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "failed to delete user")
+		}
+	}
+	return
+}
+
+// DeleteUserByForum removes the link for a forum user
+func (db *MongoStore) DeleteUserByForum(forumID string) (err error) {
+	err = db.users.Remove(bson.M{"forum_id": forumID})
+	if err != nil {
+		if err.Error() == "not found" {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "failed to delete user")
+		}
+	}
+	return
+}
+
+// CreateAuditEntry stores a record of an administrative action
+func (db *MongoStore) CreateAuditEntry(entry types.AuditEntry) (err error) {
+	err = db.audit.Insert(entry)
+	if err != nil {
+		err = errors.Wrap(err, "failed to store audit entry")
+	}
+	return
+}
+
+// CreateVerification stores a pending verification, replacing any existing one
+// for the same Discord user
+func (db *MongoStore) CreateVerification(verification types.Verification) (err error) {
+	_, err = db.verifications.Upsert(bson.M{"discord_id": verification.DiscordID}, verification)
+	if err != nil {
+		err = errors.Wrap(err, "failed to store verification")
+	}
+	return
+}
+
+// GetVerifications returns all pending verifications
+func (db *MongoStore) GetVerifications() (verifications []types.Verification, err error) {
+	err = db.verifications.Find(nil).All(&verifications)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get verifications")
+	}
+	return
+}
+
+// DeleteVerification removes a pending verification for a Discord user
+func (db *MongoStore) DeleteVerification(discordID string) (err error) {
+	err = db.verifications.Remove(bson.M{"discord_id": discordID})
+	if err != nil {
+		if err.Error() == "not found" {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "failed to delete verification")
+		}
+	}
 	return
 }
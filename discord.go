@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
@@ -12,13 +16,20 @@ import (
 func (app *App) ConnectDiscord() {
 	var err error
 
-	app.discordClient, err = discordgo.New("Bot " + app.config.DiscordToken)
+	app.discordClient, err = discordgo.New("Bot " + app.Config().DiscordToken)
 	if err != nil {
 		logger.Fatal("failed to connect to Discord API",
 			zap.Error(err))
 	}
 
+	app.discordClient.Client.Transport = restErrorCounter{app.discordClient.Client.Transport}
+
+	app.commandManager.Session = app.discordClient
+	app.commandManager.Conversations.Session = app.discordClient
+
 	app.discordClient.AddHandler(app.onReady)
+	app.discordClient.AddHandler(app.onResumed)
+	app.discordClient.AddHandler(app.onDisconnect)
 	app.discordClient.AddHandler(app.onMessage)
 	app.discordClient.AddHandler(app.onJoin)
 
@@ -29,87 +40,177 @@ func (app *App) ConnectDiscord() {
 	}
 }
 
+// onReady runs the preflight checks for every new gateway session, exiting if
+// a critical check fails on the first one
 func (app *App) onReady(s *discordgo.Session, event *discordgo.Ready) {
 	logger.Debug("discord ready")
 
-	roles, err := s.GuildRoles(app.config.GuildID)
-	if err != nil {
-		logger.Fatal("failed to get guild roles",
-			zap.Error(err))
+	ctx, cancel := context.WithTimeout(app.ctx, time.Minute)
+	defer cancel()
+
+	if app.ReportPreflight(app.Preflight(ctx)) {
+		app.lifecycle.Set(StateReady, "preflight checks passed")
+		return
 	}
 
-	found := 0
-	for _, role := range roles {
-		if role.ID == app.config.VerifiedRole {
-			found++
-		}
+	if app.lifecycle.State() == StateStarting {
+		logger.Fatal("critical preflight checks failed, see the logged results")
 	}
-	if found != 1 {
-		logger.Fatal("role not found.",
-			zap.String("role", app.config.VerifiedRole))
+	app.lifecycle.Set(StateDegraded, "critical preflight checks failed after reconnecting")
+}
+
+// onResumed checks again after a resumed session, since the guild may have
+// changed while disconnected, and only reports the results if something failed
+func (app *App) onResumed(s *discordgo.Session, event *discordgo.Resumed) {
+	logger.Debug("discord resumed")
+
+	ctx, cancel := context.WithTimeout(app.ctx, time.Minute)
+	defer cancel()
+
+	results := app.Preflight(ctx)
+	passed := true
+	for _, result := range results {
+		passed = passed && result.Passed
 	}
 
-	logger.Debug("app ready")
+	ok := true
+	if !passed {
+		ok = app.ReportPreflight(results)
+	}
+	if ok {
+		app.lifecycle.Set(StateReady, "session resumed")
+	} else {
+		app.lifecycle.Set(StateDegraded, "critical preflight checks failed after resuming")
+	}
+}
 
-	app.ready <- true
+func (app *App) onDisconnect(s *discordgo.Session, event *discordgo.Disconnect) {
+	app.lifecycle.Set(StateDisconnected, "lost gateway connection")
 }
 
+// onMessage queues a message to be processed, the job waits for the bot to be
+// ready so messages that arrive while it is starting or reconnecting are kept.
+// Commands sent while it is degraded are rejected straight away.
 func (app *App) onMessage(s *discordgo.Session, event *discordgo.MessageCreate) {
-	if len(app.ready) > 0 {
-		<-app.ready
-	}
-
-	if event.Message.Author.ID == app.config.BotID {
+	if event.Message.Author.ID == app.Config().BotID {
 		return
 	}
 
-	if app.config.DebugUser != "" {
-		if event.Message.Author.ID != app.config.DebugUser {
+	if app.Config().DebugUser != "" {
+		if event.Message.Author.ID != app.Config().DebugUser {
 			logger.Debug("ignoring command from non debug user")
 			return
 		}
 		logger.Debug("accepting command from debug user")
 	}
 
-	_, _, err := app.commandManager.Process(*event.Message)
+	message := *event.Message
+	queued := app.executor.Submit(message.Author.ID, func(ctx context.Context) error {
+		err := app.lifecycle.WaitReady(ctx)
+		if err == ErrDegraded {
+			return app.commandManager.Reject(message,
+				fmt.Sprintf("I'm %s right now, please try again in a moment.", StateDegraded))
+		}
+		if err != nil {
+			logger.Warn("dropped message, bot did not become ready in time",
+				zap.String("userID", message.Author.ID),
+				zap.Stringer("state", app.lifecycle.State()))
+			return nil
+		}
+
+		_, _, err = app.commandManager.Process(ctx, message)
+		return err
+	})
+	if !queued {
+		logger.Warn("dropped message, executor is busy or stopped",
+			zap.String("userID", message.Author.ID))
+	}
+}
+
+func (app *App) onJoin(s *discordgo.Session, event *discordgo.GuildMemberAdd) {
+	if event.Member.GuildID != app.Config().GuildID {
+		return
+	}
+
+	user, exists, err := app.GetUserByDiscord(event.Member.User.ID)
 	if err != nil {
 		app.ChannelLogError(err)
+		return
 	}
 
-	// if source != CommandSourcePRIVATE && source != CommandSourceADMINISTRATIVE {
-	// 	for i := range event.Message.Mentions {
-	// 		if event.Message.Mentions[i].ID == app.config.BotID {
-	// 			// todo: summon
-	// 		}
-	// 	}
-	// }
-}
+	if !exists {
+		ch, err := s.UserChannelCreate(event.Member.User.ID)
+		if err != nil {
+			logger.Warn("failed to create user channel", zap.Error(err))
+			return
+		}
+		_, err = s.ChannelMessageSend(ch.ID, AskUserVerify)
+		if err != nil {
+			logger.Warn("failed to send message", zap.Error(err))
+		}
+		return
+	}
 
-func (app *App) onJoin(s *discordgo.Session, event *discordgo.GuildMemberAdd) {
-	// todo: IsUserVerified
-	// if verified {
-	// 	err = app.discordClient.GuildMemberRoleAdd(app.config.GuildID, event.Member.User.ID, app.config.VerifiedRole)
-	// 	if err != nil {
-	// 		logger.Warn("failed to add verified role to member", zap.Error(err))
-	// 	}
-	// } else {
-	// 	ch, err := s.UserChannelCreate(event.Member.User.ID)
-	// 	if err != nil {
-	// 		logger.Warn("failed to create user channel", zap.Error(err))
-	// 		return
-	// 	}
-	// 	_, err = app.discordClient.ChannelMessageSend(ch.ID, app.locale.GetLangString("en", "AskUserVerify"))
-	// 	if err != nil {
-	// 		logger.Warn("failed to send message", zap.Error(err))
-	// 	}
-	// }
+	// a forum outage must not look like the account was deleted, so failures
+	// are reported for an admin to restore the roles by hand
+	member, err := app.FetchForumMember(app.ctx, user.ForumID)
+	if err != nil {
+		app.ChannelLogError(errors.Wrapf(err, "failed to restore roles for rejoining member <@%s> (forum %s)",
+			user.DiscordID, user.ForumID))
+		return
+	}
+
+	valid, reason := app.MemberValid(member)
+	if !valid {
+		logger.Info("not restoring roles for rejoining member",
+			zap.String("userID", user.DiscordID),
+			zap.String("forumID", user.ForumID),
+			zap.String("reason", reason))
+		return
+	}
+
+	_, err = app.GrantRoles(event.Member, member)
+	if err != nil {
+		app.ChannelLogError(err)
+		return
+	}
+
+	logger.Debug("restored roles for rejoining member",
+		zap.String("userID", user.DiscordID),
+		zap.String("forumID", user.ForumID))
 }
 
-// ChannelLogError sends an error to the logging channel, exiting on failure
+// ChannelLogError logs an error and reports it to the logging channel, see
+// ErrorReporter for how reports are grouped and limited
 func (app *App) ChannelLogError(err error) {
-	logger.Error("error received", zap.Error(err))
-	_, err = app.discordClient.ChannelMessageSend(app.config.LogChannel, fmt.Sprint(errors.WithStack(err)))
+	app.reporter.Report(err)
+}
+
+// restErrorCounter counts failed Discord REST requests for the metrics endpoint
+type restErrorCounter struct {
+	base http.RoundTripper
+}
+
+func (t restErrorCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
 	if err != nil {
-		logger.Fatal("failed to log error", zap.Error(err))
+		discordErrors.Inc("0")
+	} else if resp.StatusCode >= 400 {
+		discordErrors.Inc(strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// isNotFound returns true if the error is a Discord API 404 response
+func isNotFound(err error) bool {
+	restErr, ok := errors.Cause(err).(*discordgo.RESTError)
+	if !ok || restErr.Response == nil {
+		return false
 	}
+	return restErr.Response.StatusCode == http.StatusNotFound
 }
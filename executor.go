@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Job is a unit of work run by the executor, it should stop when ctx is done
+type Job func(ctx context.Context) error
+
+// queuedJob is a job along with the deadline it was given when submitted
+type queuedJob struct {
+	job      Job
+	deadline time.Time
+}
+
+// Executor runs jobs on a bounded number of workers. Each user is always
+// assigned the same worker so their jobs run one at a time, in order.
+type Executor struct {
+	timeout time.Duration
+	queues  []chan queuedJob
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	onError func(error)
+
+	lock    sync.RWMutex
+	stopped bool
+}
+
+// NewExecutor starts `workers` workers, each with a queue of `queue` jobs. Every
+// job gets a context that expires `timeout` after it was submitted, so time
+// spent waiting in the queue counts, or when the executor stops.
+func NewExecutor(workers, queue int, timeout time.Duration, onError func(error)) *Executor {
+	if workers < 1 {
+		workers = 1
+	}
+
+	e := &Executor{
+		timeout: timeout,
+		queues:  make([]chan queuedJob, workers),
+		onError: onError,
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+
+	for i := range e.queues {
+		e.queues[i] = make(chan queuedJob, queue)
+		e.wg.Add(1)
+		go e.work(e.queues[i])
+	}
+
+	return e
+}
+
+// Submit queues a job for a user, returning false if the executor has stopped
+// or the user's worker is too far behind to accept more work
+func (e *Executor) Submit(userID string, job Job) bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.stopped {
+		return false
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(userID)) // nolint:errcheck
+	queue := e.queues[hash.Sum32()%uint32(len(e.queues))]
+
+	select {
+	case queue <- queuedJob{job: job, deadline: time.Now().Add(e.timeout)}:
+		return true
+	default:
+		logger.Warn("executor queue full, dropping job",
+			zap.String("userID", userID))
+		return false
+	}
+}
+
+// Stop stops accepting jobs and waits for the queued and running jobs to finish.
+// If ctx is done first, the context of every remaining job is cancelled.
+func (e *Executor) Stop(ctx context.Context) error {
+	e.lock.Lock()
+	if !e.stopped {
+		e.stopped = true
+		for _, queue := range e.queues {
+			close(queue)
+		}
+	}
+	e.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	defer e.cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Executor) work(queue chan queuedJob) {
+	defer e.wg.Done()
+
+	for job := range queue {
+		e.run(job)
+	}
+}
+
+func (e *Executor) run(queued queuedJob) {
+	ctx, cancel := context.WithDeadline(e.ctx, queued.deadline)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil && e.onError != nil {
+			e.onError(errors.Errorf("job panicked: %v", r))
+		}
+	}()
+
+	err := queued.job(ctx)
+	if err != nil && e.onError != nil {
+		e.onError(err)
+	}
+}
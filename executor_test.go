@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecutorDeadlineIncludesQueueTime(t *testing.T) {
+	e := NewExecutor(1, 2, time.Minute, nil)
+
+	release := make(chan struct{})
+	deadline := make(chan time.Time, 1)
+
+	submitted := time.Now()
+	e.Submit("user", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	e.Submit("user", func(ctx context.Context) error {
+		d, _ := ctx.Deadline()
+		deadline <- d
+		return nil
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	err := e.Stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second job waited behind the first, its deadline must still be
+	// counted from when it was submitted
+	got := <-deadline
+	if got.After(submitted.Add(time.Minute + 40*time.Millisecond)) {
+		t.Errorf("deadline %v is %v after submission, want at most %v",
+			got, got.Sub(submitted), time.Minute)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Southclaws/invision-community-go"
+	"github.com/pkg/errors"
+)
+
+// MatchTags matches HTML tags, used to strip the formatting from group names
+var MatchTags = regexp.MustCompile(`<[^>]*>`)
+
+// GetForumMember returns a forum member, using the app cache to avoid hitting
+// the forum API for repeated lookups of the same account. Only members that
+// exist are cached, so a deleted account is always checked with the forum.
+func (app *App) GetForumMember(ctx context.Context, id string) (member ips.Member, err error) {
+	key := "member:" + id
+
+	if cached, found := app.cache.Get(key); found {
+		return cached.(ips.Member), nil
+	}
+
+	member, err = app.FetchForumMember(ctx, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get member data from forum API")
+		return
+	}
+
+	if member.ID != 0 {
+		app.cache.SetDefault(key, member)
+	}
+	return
+}
+
+// FetchForumMember returns a forum member straight from the forum API, bypassing
+// the cache. A zero member means the forum said the account does not exist, any
+// other failure is an error.
+func (app *App) FetchForumMember(ctx context.Context, id string) (member ips.Member, err error) {
+	err = app.forumGet(ctx, "member", "/api/core/members/"+url.PathEscape(id), nil, &member)
+	if isMemberNotFound(err) {
+		return ips.Member{}, nil
+	}
+	if err != nil {
+		return
+	}
+
+	// the same flattening the IPS client does, see ips.FieldGroups
+	member.CustomFields = make(map[string]ips.FieldGroups)
+	for _, fieldGroup := range member.OriginalCustomFields {
+		member.CustomFields[fieldGroup.Name] = make(ips.FieldGroups)
+		for _, field := range fieldGroup.Fields {
+			member.CustomFields[fieldGroup.Name][field.Name] = field.Value
+		}
+	}
+	return
+}
+
+// isMemberNotFound returns true if the error is the forum saying a member does
+// not exist, rather than failing to say anything about it
+func isMemberNotFound(err error) bool {
+	forumErr, ok := errors.Cause(err).(*ForumError)
+	return ok && (forumErr.Status == http.StatusNotFound || forumErr.Message == "INVALID_ID")
+}
+
+// forumHTTP is used for forum API calls that the IPS client does not provide
+var forumHTTP = &http.Client{Timeout: 20 * time.Second}
+
+// ForumError is an error response from the forum API, Message is the IPS error
+// such as INVALID_ID or NO_PERMISSION and Code is where it was raised
+type ForumError struct {
+	Status  int
+	Code    string `json:"errorCode"`
+	Message string `json:"errorMessage"`
+}
+
+func (e *ForumError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("forum API returned %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("forum API returned %d %s: %s (%s)", e.Status, http.StatusText(e.Status), e.Message, e.Code)
+}
+
+// SearchForumMembers finds forum members by name, the IPS client has no search
+// so this calls the members endpoint directly
+func (app *App) SearchForumMembers(ctx context.Context, name string) (members []ips.Member, err error) {
+	var result struct {
+		Results []ips.Member `json:"results"`
+	}
+	err = app.forumGet(ctx, "search", "/api/core/members", url.Values{"name": {name}}, &result)
+	if err != nil {
+		err = errors.Wrap(err, "failed to search forum members")
+		return
+	}
+
+	return result.Results, nil
+}
+
+// forumGet calls a forum API endpoint and decodes the response into result, if
+// it is not nil. Non-2xx responses are returned as a *ForumError and errors never
+// include the request URL, because it contains the API key. The request and any
+// error are recorded in the forum metrics under call.
+func (app *App) forumGet(ctx context.Context, call, path string, query url.Values, result interface{}) (err error) {
+	start := time.Now()
+	defer func() {
+		forumDuration.Since(start, call)
+		if err != nil {
+			forumErrors.Inc(call)
+		}
+	}()
+
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("key", app.Config().ForumKey)
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(app.Config().ForumEndpoint, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return errors.Wrap(stripURL(err), "failed to create forum API request")
+	}
+
+	resp, err := forumHTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(stripURL(err), "failed to reach forum API")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		forumErr := &ForumError{Status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(forumErr) // nolint:errcheck
+		return forumErr
+	}
+
+	if result == nil {
+		return
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(result), "failed to decode forum API response")
+}
+
+// stripURL removes the request URL from an HTTP client error
+func stripURL(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return errors.Errorf("%s: %v", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// stripTags removes any HTML tags from a string
+func stripTags(s string) string {
+	return MatchTags.ReplaceAllString(s, "")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// StartStatus serves /healthz, /readyz and /metrics on StatusAddress, it does
+// nothing if no address is configured
+func (app *App) StartStatus() {
+	if app.Config().StatusAddress == "" {
+		logger.Debug("status endpoint disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n")) // nolint:errcheck
+	})
+	mux.HandleFunc("/readyz", app.serveReadiness)
+	mux.HandleFunc("/metrics", serveMetrics)
+
+	app.status = &http.Server{
+		Addr:    app.Config().StatusAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		err := app.status.ListenAndServe()
+		if err != http.ErrServerClosed {
+			logger.Fatal("status endpoint stopped",
+				zap.Error(err))
+		}
+	}()
+}
+
+// Readiness runs every readiness check and returns the failures by name, an
+// empty map means the bot is ready to serve commands
+func (app *App) Readiness(ctx context.Context) (failures map[string]error) {
+	failures = make(map[string]error)
+
+	if state := app.lifecycle.State(); state != StateReady {
+		failures["discord"] = errors.Errorf("bot is %s", state)
+	}
+
+	err := app.Store.Ping()
+	if err != nil {
+		failures["store"] = errors.Wrap(err, "failed to ping store")
+	}
+
+	err = app.PingForum(ctx)
+	if err != nil {
+		failures["forum"] = err
+	}
+
+	return
+}
+
+func (app *App) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	failures := app.Readiness(ctx)
+
+	checks := map[string]string{"discord": "ok", "store": "ok", "forum": "ok"}
+	for name, err := range failures {
+		checks[name] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(checks) // nolint:errcheck
+}
+
+// PingForum checks the forum API is alive with its hello endpoint, successful
+// checks are cached for a short time
+func (app *App) PingForum(ctx context.Context) (err error) {
+	if _, found := app.cache.Get("forum:alive"); found {
+		return nil
+	}
+
+	err = app.forumGet(ctx, "hello", "/api/core/hello", nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "forum aliveness check failed")
+	}
+
+	app.cache.Set("forum:alive", true, 30*time.Second)
+	return nil
+}
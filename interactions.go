@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// DiscordAPI is the base URL for API endpoints that discordgo does not support,
+// application commands need a newer API version than discordgo uses
+const DiscordAPI = "https://discord.com/api/v10/"
+
+// InteractionType represents the kind of interaction Discord has sent
+type InteractionType int
+
+const (
+	// InteractionPing is sent by Discord to check the endpoint is alive.
+	InteractionPing InteractionType = 1
+	// InteractionApplicationCommand is sent when a user runs a slash command.
+	InteractionApplicationCommand InteractionType = 2
+	// InteractionAutocomplete is sent while a user is typing an option that
+	// has autocomplete enabled.
+	InteractionAutocomplete InteractionType = 4
+)
+
+// InteractionResponseType represents the kind of response sent to Discord
+type InteractionResponseType int
+
+const (
+	// ResponsePong acknowledges a ping.
+	ResponsePong InteractionResponseType = 1
+	// ResponseChannelMessage replies to a command with a message.
+	ResponseChannelMessage InteractionResponseType = 4
+	// ResponseDeferredChannelMessage acknowledges a command that will finish
+	// later, the user sees a loading state until the original response is
+	// edited or deleted.
+	ResponseDeferredChannelMessage InteractionResponseType = 5
+	// ResponseAutocompleteResult returns autocomplete choices.
+	ResponseAutocompleteResult InteractionResponseType = 8
+)
+
+// Application command option types, as documented by Discord
+const (
+	optionString  = 3
+	optionInteger = 4
+	optionUser    = 6
+	optionChannel = 7
+	optionRole    = 8
+)
+
+// flagEphemeral marks an interaction response as only visible to the user
+const flagEphemeral = 64
+
+// Interaction contexts, where a slash command can be used
+const (
+	contextGuild = 0
+	contextBotDM = 1
+)
+
+// Interaction is an incoming interaction from Discord
+type Interaction struct {
+	ID        string            `json:"id"`
+	Type      InteractionType   `json:"type"`
+	Data      InteractionData   `json:"data"`
+	GuildID   string            `json:"guild_id"`
+	ChannelID string            `json:"channel_id"`
+	Member    *discordgo.Member `json:"member"`
+	User      *discordgo.User   `json:"user"`
+	Token     string            `json:"token"`
+}
+
+// InteractionData is the command and options of an interaction
+type InteractionData struct {
+	Name    string              `json:"name"`
+	Options []InteractionOption `json:"options"`
+}
+
+// InteractionOption is a single option value given to a slash command
+type InteractionOption struct {
+	Name    string          `json:"name"`
+	Type    int             `json:"type"`
+	Value   json.RawMessage `json:"value"`
+	Focused bool            `json:"focused"`
+}
+
+// InteractionResponse is the reply to an interaction
+type InteractionResponse struct {
+	Type InteractionResponseType  `json:"type"`
+	Data *InteractionResponseData `json:"data,omitempty"`
+}
+
+// InteractionResponseData is the content of an interaction response
+type InteractionResponseData struct {
+	Content string              `json:"content,omitempty"`
+	Flags   int                 `json:"flags,omitempty"`
+	Choices []ApplicationChoice `json:"choices,omitempty"`
+}
+
+// ApplicationCommand is a slash command definition registered with Discord
+type ApplicationCommand struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Options     []ApplicationOption `json:"options,omitempty"`
+	Contexts    []int               `json:"contexts,omitempty"`
+}
+
+// ApplicationOption is a single option of a slash command definition
+type ApplicationOption struct {
+	Type         int    `json:"type"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Required     bool   `json:"required"`
+	Autocomplete bool   `json:"autocomplete,omitempty"`
+}
+
+// ApplicationChoice is an autocomplete suggestion
+type ApplicationChoice struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// InteractionsServer receives interactions over HTTP, verifying that each one
+// was signed by Discord before passing it to Handler
+type InteractionsServer struct {
+	PublicKey ed25519.PublicKey
+	Handler   func(interaction Interaction) InteractionResponse
+}
+
+func (is InteractionsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if !VerifyInteraction(is.PublicKey, r.Header, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction Interaction
+	err = json.Unmarshal(body, &interaction)
+	if err != nil {
+		http.Error(w, "invalid interaction", http.StatusBadRequest)
+		return
+	}
+
+	response := InteractionResponse{Type: ResponsePong}
+	if interaction.Type != InteractionPing {
+		response = is.Handler(interaction)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		logger.Warn("failed to write interaction response", zap.Error(err))
+	}
+}
+
+// VerifyInteraction checks the Ed25519 signature Discord sends with every
+// interaction, which covers the timestamp header followed by the body
+func VerifyInteraction(key ed25519.PublicKey, header http.Header, body []byte) bool {
+	signature, err := hex.DecodeString(header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	timestamp := header.Get("X-Signature-Timestamp")
+	if timestamp == "" {
+		return false
+	}
+	return ed25519.Verify(key, append([]byte(timestamp), body...), signature)
+}
+
+// StartInteractions registers every command as a slash command and serves the
+// interactions endpoint, it does nothing if InteractionsAddress is not set
+func (app *App) StartInteractions() {
+	if app.Config().InteractionsAddress == "" {
+		logger.Debug("interactions endpoint disabled")
+		return
+	}
+
+	key, err := hex.DecodeString(app.Config().DiscordPublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		logger.Fatal("invalid Discord public key",
+			zap.Error(err))
+	}
+
+	err = app.RegisterApplicationCommands()
+	if err != nil {
+		app.ChannelLogError(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/interactions", InteractionsServer{
+		PublicKey: key,
+		Handler:   app.commandManager.HandleInteraction,
+	})
+
+	app.interactions = &http.Server{
+		Addr:    app.Config().InteractionsAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		err := app.interactions.ListenAndServe()
+		if err != http.ErrServerClosed {
+			logger.Fatal("interactions endpoint stopped",
+				zap.Error(err))
+		}
+	}()
+}
+
+// RegisterApplicationCommands replaces the bot's slash commands with the
+// commands loaded by the command manager. Guild commands can't be used in direct
+// messages, so commands that can are registered globally instead.
+func (app *App) RegisterApplicationCommands() (err error) {
+	var global, guild []ApplicationCommand
+	for trigger, command := range app.commandManager.Commands {
+		definition := applicationCommand(trigger, command)
+		if len(definition.Contexts) > 0 {
+			global = append(global, definition)
+		} else {
+			guild = append(guild, definition)
+		}
+	}
+	sort.Slice(global, func(i, j int) bool { return global[i].Name < global[j].Name })
+	sort.Slice(guild, func(i, j int) bool { return guild[i].Name < guild[j].Name })
+
+	_, err = app.discordClient.RequestWithBucketID("PUT",
+		fmt.Sprintf("%sapplications/%s/commands", DiscordAPI, app.Config().BotID),
+		global,
+		"applications/commands")
+	if err != nil {
+		return errors.Wrap(err, "failed to register global application commands")
+	}
+
+	_, err = app.discordClient.RequestWithBucketID("PUT",
+		fmt.Sprintf("%sapplications/%s/guilds/%s/commands", DiscordAPI, app.Config().BotID, app.Config().GuildID),
+		guild,
+		"applications/guilds/commands")
+	if err != nil {
+		return errors.Wrap(err, "failed to register guild application commands")
+	}
+
+	logger.Debug("registered application commands",
+		zap.Int("global", len(global)),
+		zap.Int("guild", len(guild)))
+	return
+}
+
+// HandleInteraction routes a slash command interaction into the same checks
+// and handlers as a text command
+func (cm CommandManager) HandleInteraction(interaction Interaction) InteractionResponse {
+	command, exists := cm.Commands[interaction.Data.Name]
+	if !exists {
+		return ephemeral(fmt.Sprintf("Unknown command `%s`.", interaction.Data.Name))
+	}
+
+	if interaction.Type == InteractionAutocomplete {
+		return cm.autocomplete(command, interaction)
+	}
+
+	// interactions must be answered within seconds, so they can't wait like
+	// messages do
+	if state := cm.App.lifecycle.State(); state != StateReady {
+		return ephemeral(fmt.Sprintf("I'm %s right now, please try again in a moment.", state))
+	}
+
+	author := interaction.User
+	if interaction.Member != nil {
+		author = interaction.Member.User
+	}
+	if author == nil {
+		return ephemeral("Could not tell who ran this command.")
+	}
+
+	// interactions must be answered, so unlike messages they can't be ignored
+	if debugUser := cm.App.Config().DebugUser; debugUser != "" && author.ID != debugUser {
+		logger.Debug("rejecting interaction from non debug user")
+		return ephemeral("I'm only accepting commands from the debug user right now.")
+	}
+
+	message := discordgo.Message{
+		ID:        interaction.ID,
+		ChannelID: interaction.ChannelID,
+		Author:    author,
+		Content:   "/" + interaction.Data.Name,
+	}
+
+	args, err := optionArguments(command, interaction.Data.Options)
+	if err != nil {
+		commandsProcessed.Inc(interaction.Data.Name, "invalid")
+		return ephemeral(fmt.Sprintf("Sorry, %s.\n%s", err, command.Help("/", interaction.Data.Name)))
+	}
+
+	// Discord wants a response within 3 seconds, so checks that call Discord
+	// run in the job after the interaction has been deferred
+	queued := cm.App.executor.Submit(author.ID, func(ctx context.Context) (err error) {
+		denial := ""
+		defer func() {
+			cm.App.finishInteraction(interaction, denial, err)
+		}()
+
+		source, err := cm.getCommandSource(message)
+		if err != nil {
+			return
+		}
+
+		denial, err = cm.authorise(command, interaction.Data.Name, source, message)
+		if err != nil || denial != "" {
+			return
+		}
+
+		return cm.run(ctx, command, interaction.Data.Name, args, message, false)
+	})
+	if !queued {
+		return ephemeral("I'm too busy right now, please try again in a moment.")
+	}
+
+	return InteractionResponse{
+		Type: ResponseDeferredChannelMessage,
+		Data: &InteractionResponseData{Flags: flagEphemeral},
+	}
+}
+
+// finishInteraction replaces the loading state of a deferred interaction with
+// a reply, or an error notice if the command failed, and clears it if the
+// command replied by itself
+func (app *App) finishInteraction(interaction Interaction, reply string, cmdErr error) {
+	endpoint := fmt.Sprintf("%swebhooks/%s/%s/messages/@original", DiscordAPI, app.Config().BotID, interaction.Token)
+
+	if cmdErr != nil {
+		reply = "Something went wrong, please try again later."
+	}
+
+	var err error
+	if reply == "" {
+		_, err = app.discordClient.RequestWithBucketID("DELETE", endpoint, nil, "webhooks/interactions")
+	} else {
+		_, err = app.discordClient.RequestWithBucketID("PATCH", endpoint,
+			InteractionResponseData{Content: reply},
+			"webhooks/interactions")
+	}
+	if err != nil {
+		logger.Warn("failed to finish interaction",
+			zap.Error(err))
+	}
+}
+
+func (cm CommandManager) autocomplete(command Command, interaction Interaction) InteractionResponse {
+	response := InteractionResponse{
+		Type: ResponseAutocompleteResult,
+		Data: &InteractionResponseData{Choices: []ApplicationChoice{}},
+	}
+
+	for _, option := range interaction.Data.Options {
+		if !option.Focused {
+			continue
+		}
+
+		var partial string
+		if json.Unmarshal(option.Value, &partial) != nil {
+			continue
+		}
+
+		for _, arg := range command.Arguments {
+			if optionName(arg.Name) != option.Name || arg.Suggest == nil {
+				continue
+			}
+			for _, suggestion := range arg.Suggest(partial) {
+				if len(response.Data.Choices) == 25 {
+					break
+				}
+				response.Data.Choices = append(response.Data.Choices, ApplicationChoice{Name: suggestion, Value: suggestion})
+			}
+		}
+	}
+
+	return response
+}
+
+func ephemeral(content string) InteractionResponse {
+	return InteractionResponse{
+		Type: ResponseChannelMessage,
+		Data: &InteractionResponseData{
+			Content: content,
+			Flags:   flagEphemeral,
+		},
+	}
+}
+
+// optionArguments converts slash command options into the same Arguments that
+// ParseArguments would produce for a text command
+func optionArguments(command Command, options []InteractionOption) (args Arguments, err error) {
+	args = make(Arguments)
+
+	for i := range command.Arguments {
+		arg := &command.Arguments[i]
+
+		var option *InteractionOption
+		for j := range options {
+			if options[j].Name == optionName(arg.Name) {
+				option = &options[j]
+			}
+		}
+		if option == nil {
+			if arg.Optional {
+				continue
+			}
+			return nil, ArgumentError{Argument: arg, Position: i}
+		}
+
+		if arg.Type == ArgumentInteger {
+			var value int
+			err = json.Unmarshal(option.Value, &value)
+			if err != nil {
+				return nil, ArgumentError{Argument: arg, Position: i, Value: string(option.Value)}
+			}
+			args[arg.Name] = value
+			continue
+		}
+
+		var raw string
+		err = json.Unmarshal(option.Value, &raw)
+		if err != nil {
+			return nil, ArgumentError{Argument: arg, Position: i, Value: string(option.Value)}
+		}
+		if arg.Type == ArgumentRest {
+			args[arg.Name] = raw
+			continue
+		}
+		value, ok := parseValue(arg.Type, raw)
+		if !ok {
+			return nil, ArgumentError{Argument: arg, Position: i, Value: raw}
+		}
+		args[arg.Name] = value
+	}
+
+	return args, nil
+}
+
+// applicationCommand converts a command into a slash command definition, only
+// commands that can be used in direct messages have contexts, for which they
+// must be registered globally
+func applicationCommand(trigger string, command Command) ApplicationCommand {
+	definition := ApplicationCommand{
+		Name:        trigger,
+		Description: truncate(command.Description, 100),
+	}
+
+	inGuild, inDM := false, false
+	for _, source := range command.Sources {
+		if source == CommandSourcePRIVATE {
+			inDM = true
+		} else {
+			inGuild = true
+		}
+	}
+	if inDM {
+		if inGuild {
+			definition.Contexts = append(definition.Contexts, contextGuild)
+		}
+		definition.Contexts = append(definition.Contexts, contextBotDM)
+	}
+
+	for _, arg := range command.Arguments {
+		description := arg.Name
+		if arg.Prompt != "" {
+			description = strings.SplitN(arg.Prompt, "\n", 2)[0]
+		}
+
+		definition.Options = append(definition.Options, ApplicationOption{
+			Type:         optionType(arg.Type),
+			Name:         optionName(arg.Name),
+			Description:  truncate(description, 100),
+			Required:     !arg.Optional,
+			Autocomplete: arg.Suggest != nil,
+		})
+	}
+
+	return definition
+}
+
+func optionType(t ArgumentType) int {
+	switch t {
+	case ArgumentUser:
+		return optionUser
+	case ArgumentChannel:
+		return optionChannel
+	case ArgumentRole:
+		return optionRole
+	case ArgumentInteger:
+		return optionInteger
+	}
+	return optionString
+}
+
+// matchOptionInvalid matches characters that are not allowed in option names
+var matchOptionInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// optionName turns an argument name into a valid slash command option name
+func optionName(name string) string {
+	name = strings.Replace(strings.ToLower(name), " ", "_", -1)
+	return truncate(matchOptionInvalid.ReplaceAllString(name, ""), 32)
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length]
+}
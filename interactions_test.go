@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// signedRequest builds an interaction request signed the way Discord signs it
+func signedRequest(key ed25519.PrivateKey, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/interactions", bytes.NewBufferString(body))
+	timestamp := "1700000000"
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(timestamp+body))))
+	return req
+}
+
+func generateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+func TestVerifyInteraction(t *testing.T) {
+	public, private := generateKey(t)
+	_, otherPrivate := generateKey(t)
+	body := `{"type":1}`
+
+	for _, tt := range []struct {
+		name   string
+		header func(h http.Header)
+		body   string
+		want   bool
+	}{
+		{"good signature", func(h http.Header) {}, body, true},
+		{"tampered body", func(h http.Header) {}, `{"type":2}`, false},
+		{"tampered timestamp", func(h http.Header) { h.Set("X-Signature-Timestamp", "1700000001") }, body, false},
+		{"signed by another key", func(h http.Header) {
+			h.Set("X-Signature-Ed25519", signedRequest(otherPrivate, body).Header.Get("X-Signature-Ed25519"))
+		}, body, false},
+		{"missing timestamp", func(h http.Header) { h.Del("X-Signature-Timestamp") }, body, false},
+		{"missing signature", func(h http.Header) { h.Del("X-Signature-Ed25519") }, body, false},
+		{"signature not hex", func(h http.Header) { h.Set("X-Signature-Ed25519", "not hex") }, body, false},
+		{"short signature", func(h http.Header) { h.Set("X-Signature-Ed25519", "abcd") }, body, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(private, body)
+			tt.header(req.Header)
+
+			if got := VerifyInteraction(public, req.Header, []byte(tt.body)); got != tt.want {
+				t.Errorf("VerifyInteraction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInteractionsServer(t *testing.T) {
+	public, private := generateKey(t)
+	_, otherPrivate := generateKey(t)
+
+	var handled []Interaction
+	server := InteractionsServer{
+		PublicKey: public,
+		Handler: func(interaction Interaction) InteractionResponse {
+			handled = append(handled, interaction)
+			return ephemeral("handled")
+		},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		req         *http.Request
+		wantStatus  int
+		wantType    InteractionResponseType
+		wantHandled int
+	}{
+		{"ping", signedRequest(private, `{"id":"1","type":1}`), http.StatusOK, ResponsePong, 0},
+		{"command", signedRequest(private, `{"id":"2","type":2,"data":{"name":"help"}}`), http.StatusOK, ResponseChannelMessage, 1},
+		{"unsigned ping", httptest.NewRequest("POST", "/interactions", bytes.NewBufferString(`{"type":1}`)), http.StatusUnauthorized, 0, 0},
+		{"ping signed by another key", signedRequest(otherPrivate, `{"type":1}`), http.StatusUnauthorized, 0, 0},
+		{"signed invalid JSON", signedRequest(private, `{"type":`), http.StatusBadRequest, 0, 0},
+		{"GET", httptest.NewRequest("GET", "/interactions", nil), http.StatusMethodNotAllowed, 0, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			handled = nil
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, tt.req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if len(handled) != tt.wantHandled {
+				t.Errorf("handler called %d times, want %d", len(handled), tt.wantHandled)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response InteractionResponse
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Type != tt.wantType {
+				t.Errorf("response type = %d, want %d", response.Type, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestOptionArguments(t *testing.T) {
+	command := Command{
+		Arguments: []Argument{
+			{Name: "user", Type: ArgumentUser},
+			{Name: "count", Type: ArgumentInteger, Optional: true},
+			{Name: "profile page URL", Type: ArgumentURL, Optional: true},
+			{Name: "reason", Type: ArgumentRest, Optional: true},
+		},
+	}
+	option := func(name string, value string) InteractionOption {
+		return InteractionOption{Name: name, Value: json.RawMessage(value)}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		options []InteractionOption
+		want    Arguments
+		wantErr bool
+	}{
+		{
+			name:    "required only",
+			options: []InteractionOption{option("user", `"123"`)},
+			want:    Arguments{"user": "123"},
+		},
+		{
+			name: "every option",
+			options: []InteractionOption{
+				option("reason", `"some words here"`),
+				option("count", `3`),
+				option("user", `"123"`),
+				option("profile_page_url", `"https://forum.example.com/profile/21-someone/"`),
+			},
+			want: Arguments{
+				"user":             "123",
+				"count":            3,
+				"profile page URL": "https://forum.example.com/profile/21-someone/",
+				"reason":           "some words here",
+			},
+		},
+		{
+			name:    "missing required",
+			options: []InteractionOption{option("count", `3`)},
+			wantErr: true,
+		},
+		{
+			name:    "integer given as a string",
+			options: []InteractionOption{option("user", `"123"`), option("count", `"3"`)},
+			wantErr: true,
+		},
+		{
+			name:    "invalid user",
+			options: []InteractionOption{option("user", `"someone"`)},
+			wantErr: true,
+		},
+		{
+			name:    "invalid URL",
+			options: []InteractionOption{option("user", `"123"`), option("profile_page_url", `"not a url"`)},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := optionArguments(command, tt.options)
+			if tt.wantErr {
+				if _, ok := err.(ArgumentError); !ok {
+					t.Errorf("err = %v, want an ArgumentError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(args) != len(tt.want) {
+				t.Errorf("args = %v, want %v", args, tt.want)
+			}
+			// compared as text since URLs are parsed into a *url.URL
+			for name, want := range tt.want {
+				if fmt.Sprint(args[name]) != fmt.Sprint(want) {
+					t.Errorf("args[%q] = %#v, want %#v", name, args[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplicationCommandContexts(t *testing.T) {
+	for _, tt := range []struct {
+		sources []CommandSource
+		want    string
+	}{
+		{[]CommandSource{CommandSourcePRIVATE}, "[1]"},
+		{[]CommandSource{CommandSourcePRIMARY, CommandSourcePRIVATE}, "[0 1]"},
+		{[]CommandSource{CommandSourceADMINISTRATIVE}, "[]"},
+	} {
+		definition := applicationCommand("test", Command{Sources: tt.sources})
+		if got := fmt.Sprint(definition.Contexts); got != tt.want {
+			t.Errorf("contexts for %v = %s, want %s", tt.sources, got, tt.want)
+		}
+	}
+}
+
+func TestHandleInteractionDebugUser(t *testing.T) {
+	cm := newTestCommandManager(&fakeSession{}, make(map[string]int))
+	config := cm.App.Config()
+	config.DebugUser = "debugger"
+	cm.App.liveConfig.Store(config)
+	cm.App.lifecycle = NewLifecycle()
+	cm.App.lifecycle.Set(StateReady, "test")
+	// an executor without workers, so accepted jobs are queued but never run
+	cm.App.executor = &Executor{queues: []chan queuedJob{make(chan queuedJob, 1)}}
+
+	for _, tt := range []struct {
+		author string
+		want   InteractionResponseType
+	}{
+		{"someone", ResponseChannelMessage},
+		{"debugger", ResponseDeferredChannelMessage},
+	} {
+		response := cm.HandleInteraction(Interaction{
+			Type: InteractionApplicationCommand,
+			User: &discordgo.User{ID: tt.author},
+			Data: InteractionData{Name: "open"},
+		})
+		if response.Type != tt.want {
+			t.Errorf("response to %s = %d, want %d", tt.author, response.Type, tt.want)
+		}
+	}
+}
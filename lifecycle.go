@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// State is where the bot is in its lifecycle
+type State int
+
+const (
+	// StateStarting is before the first Ready event and its preflight checks
+	StateStarting State = iota
+	// StateReady is connected to Discord with the preflight checks passed
+	StateReady
+	// StateDisconnected is after losing the gateway connection, until it is
+	// resumed or a new session is ready
+	StateDisconnected
+	// StateDegraded is connected but a critical check failed on revalidation
+	StateDegraded
+	// StateStopping is during shutdown
+	StateStopping
+)
+
+func (state State) String() string {
+	switch state {
+	case StateStarting:
+		return "starting"
+	case StateReady:
+		return "ready"
+	case StateDisconnected:
+		return "disconnected"
+	case StateDegraded:
+		return "degraded"
+	case StateStopping:
+		return "stopping"
+	}
+	return "unknown"
+}
+
+// Lifecycle tracks the bot's state so commands can wait until it is ready
+type Lifecycle struct {
+	lock    sync.Mutex
+	state   State
+	changed chan struct{} // closed and replaced on every change
+}
+
+// NewLifecycle creates a lifecycle in StateStarting
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{
+		state:   StateStarting,
+		changed: make(chan struct{}),
+	}
+}
+
+// State returns the current state
+func (l *Lifecycle) State() State {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.state
+}
+
+// Set changes the state and logs the reason, once stopping the state can't change
+func (l *Lifecycle) Set(state State, reason string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.state == state || l.state == StateStopping {
+		return
+	}
+
+	logger.Info("state changed",
+		zap.Stringer("from", l.state),
+		zap.Stringer("to", state),
+		zap.String("reason", reason))
+
+	l.state = state
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// ErrStopping is returned by WaitReady once the bot is shutting down
+var ErrStopping = errors.New("bot is stopping")
+
+// ErrDegraded is returned by WaitReady while the bot is degraded, which needs
+// an operator rather than time to recover
+var ErrDegraded = errors.New("bot is degraded")
+
+// WaitReady blocks while the bot is starting or disconnected, returning nil once
+// the state is StateReady, ErrDegraded or ErrStopping if it becomes degraded or
+// starts shutting down, or the context's error if it is done first
+func (l *Lifecycle) WaitReady(ctx context.Context) error {
+	for {
+		l.lock.Lock()
+		state, changed := l.state, l.changed
+		l.lock.Unlock()
+
+		if state == StateReady {
+			return nil
+		}
+		if state == StateStopping {
+			return ErrStopping
+		}
+		if state == StateDegraded {
+			return ErrDegraded
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
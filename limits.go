@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Cooldown limits a command to Limit uses per user within Window, a zero Limit
+// means the command has no cooldown
+type Cooldown struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Limiter tracks command cooldowns and the number of active invocations of each
+// command per user, all state lives in the app cache
+type Limiter struct {
+	cache *cache.Cache
+	lock  sync.Mutex
+}
+
+// NewLimiter creates a limiter that stores its state in the given cache
+func NewLimiter(c *cache.Cache) *Limiter {
+	return &Limiter{cache: c}
+}
+
+// Allow records a use of a command by a user if it is within the cooldown and
+// returns zero, otherwise it returns how long until the user may try again
+func (l *Limiter) Allow(name, userID string, cooldown Cooldown) (wait time.Duration) {
+	if cooldown.Limit <= 0 {
+		return 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	key := "cooldown:" + name + ":" + userID
+	now := time.Now()
+
+	var uses []time.Time
+	if cached, found := l.cache.Get(key); found {
+		for _, use := range cached.([]time.Time) {
+			if now.Sub(use) < cooldown.Window {
+				uses = append(uses, use)
+			}
+		}
+	}
+
+	if len(uses) >= cooldown.Limit {
+		return cooldown.Window - now.Sub(uses[0])
+	}
+
+	l.cache.Set(key, append(uses, now), cooldown.Window)
+	return 0
+}
+
+// Acquire takes one of a user's `limit` active slots for a command, returning
+// false if they are all in use. A limit of zero is unlimited.
+func (l *Limiter) Acquire(name, userID string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.active(name, userID) >= limit {
+		return false
+	}
+	l.cache.Set(l.activeKey(name, userID), l.active(name, userID)+1, cache.NoExpiration)
+	return true
+}
+
+// Hold takes an active slot regardless of the limit, for commands that carry on
+// working in the background after they return. Each Hold needs a Release.
+func (l *Limiter) Hold(name, userID string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.cache.Set(l.activeKey(name, userID), l.active(name, userID)+1, cache.NoExpiration)
+}
+
+// Release frees an active slot taken by Acquire or Hold
+func (l *Limiter) Release(name, userID string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	active := l.active(name, userID) - 1
+	if active <= 0 {
+		l.cache.Delete(l.activeKey(name, userID))
+		return
+	}
+	l.cache.Set(l.activeKey(name, userID), active, cache.NoExpiration)
+}
+
+func (l *Limiter) active(name, userID string) int {
+	if cached, found := l.cache.Get(l.activeKey(name, userID)); found {
+		return cached.(int)
+	}
+	return 0
+}
+
+func (l *Limiter) activeKey(name, userID string) string {
+	return "active:" + name + ":" + userID
+}
@@ -3,12 +3,12 @@ package main
 import (
 	"os"
 	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	// loads environment variables from .env
 	_ "github.com/joho/godotenv/autoload"
-	"github.com/kelseyhightower/envconfig"
 )
 
 var logger *zap.Logger
@@ -42,32 +42,64 @@ func init() {
 	)
 }
 
-// Config stores configuration variables
+// Config stores configuration variables, loaded from MACCER_* environment
+// variables with the optional config file layered over them. Fields tagged
+// `reload` are applied when the config file is reloaded, the rest need a restart.
 type Config struct {
-	DiscordToken          string `split_words:"true" required:"true"` // discord API token
-	BotID                 string `split_words:"true" required:"true"` // the bot's client ID
-	GuildID               string `split_words:"true" required:"true"` // the discord server ID
-	VerifiedRole          string `split_words:"true" required:"true"` // ID of the role for verified members
-	DebugUser             string `split_words:"true" required:"true"` // When set, only this user can interact with the bot
-	AdministrativeChannel string `split_words:"true" required:"true"` // administrative channel where someone can speak as bot
-	PrimaryChannel        string `split_words:"true" required:"true"` // main channel the bot hangs out in
-	LogChannel            string `split_words:"true" required:"true"` // logging channel for errors etc
-	ForumEndpoint         string `split_words:"true" required:"true"` // Forum URL
-	ForumKey              string `split_words:"true" required:"true"` // API key
-	MongoHost             string `split_words:"true" required:"true"` // MongoDB host address
-	MongoPort             string `split_words:"true" required:"true"` // MongoDB host port
-	MongoName             string `split_words:"true" required:"true"` // MongoDB database name
-	MongoUser             string `split_words:"true" required:"true"` // MongoDB user name
-	MongoPass             string `split_words:"true"`                 // MongoDB password
+	ConfigFile            string `split_words:"true" yaml:"-"`                                                           // path to a YAML config file, empty disables
+	DiscordToken          string `split_words:"true" yaml:"discord_token" validate:"required" secret:"true"`             // discord API token
+	BotID                 string `split_words:"true" yaml:"bot_id" validate:"required,id"`                               // the bot's client ID
+	GuildID               string `split_words:"true" yaml:"guild_id" validate:"required,id"`                             // the discord server ID
+	VerifiedRole          string `split_words:"true" yaml:"verified_role" validate:"required,id" reload:"true"`          // ID of the role for verified members
+	DebugUser             string `split_words:"true" yaml:"debug_user" validate:"id" reload:"true"`                      // When set, only this user can interact with the bot
+	AdministrativeChannel string `split_words:"true" yaml:"administrative_channel" validate:"required,id" reload:"true"` // administrative channel where someone can speak as bot
+	PrimaryChannel        string `split_words:"true" yaml:"primary_channel" validate:"required,id" reload:"true"`        // main channel the bot hangs out in
+	LogChannel            string `split_words:"true" yaml:"log_channel" validate:"required,id" reload:"true"`            // logging channel for errors etc
+	ForumEndpoint         string `split_words:"true" yaml:"forum_endpoint" validate:"required"`                          // Forum URL
+	ForumKey              string `split_words:"true" yaml:"forum_key" validate:"required" secret:"true"`                 // API key
+	MongoHost             string `split_words:"true" yaml:"mongo_host"`                                                  // MongoDB host address
+	MongoPort             string `split_words:"true" yaml:"mongo_port"`                                                  // MongoDB host port
+	MongoName             string `split_words:"true" yaml:"mongo_name"`                                                  // MongoDB database name
+	MongoUser             string `split_words:"true" yaml:"mongo_user"`                                                  // MongoDB user name
+	MongoPass             string `split_words:"true" yaml:"mongo_pass" secret:"true"`                                    // MongoDB password
+
+	GroupRoles          map[string]string `split_words:"true" yaml:"group_roles" reload:"true"`                       // forum group ID to Discord role ID, as "group:role,group:role"
+	BannedGroup         string            `split_words:"true" yaml:"banned_group" validate:"id" reload:"true"`        // forum group ID of banned members
+	ReconcileInterval   time.Duration     `split_words:"true" yaml:"reconcile_interval" default:"1h"`                 // how often to re-check all linked accounts, 0 disables
+	ReconcileRate       time.Duration     `split_words:"true" yaml:"reconcile_rate" default:"2s" reload:"true"`       // delay between each account during a re-check
+	ReconcileMaxRevoke  int               `split_words:"true" yaml:"reconcile_max_revoke" default:"10" reload:"true"` // revocations per re-check before the rest are held back
+	AdminRoles          []string          `split_words:"true" yaml:"admin_roles" validate:"id" reload:"true"`         // IDs of roles allowed to use administrative commands
+	CommandPrefix       string            `split_words:"true" yaml:"command_prefix" default:"!" reload:"true"`        // prefix for commands outside of direct messages
+	OtherChannels       []string          `split_words:"true" yaml:"other_channels" validate:"id" reload:"true"`      // IDs of extra channels where help and whois may be used
+	InteractionsAddress string            `split_words:"true" yaml:"interactions_address"`                            // listen address for the slash command endpoint, empty disables
+	DiscordPublicKey    string            `split_words:"true" yaml:"discord_public_key"`                              // application public key used to verify interactions
+	ConversationTimeout time.Duration     `split_words:"true" yaml:"conversation_timeout" default:"5m"`               // how long to wait for an answer to a question
+	Workers             int               `split_words:"true" yaml:"workers" default:"8"`                             // number of commands that can run at once
+	WorkerQueue         int               `split_words:"true" yaml:"worker_queue" default:"32"`                       // commands each worker can have waiting before new ones are dropped
+	CommandTimeout      time.Duration     `split_words:"true" yaml:"command_timeout" default:"30s"`                   // deadline for a command to finish, counted from when it is queued
+	ShutdownTimeout     time.Duration     `split_words:"true" yaml:"shutdown_timeout" default:"10s"`                  // how long to wait for running work when stopping
+	StatusAddress       string            `split_words:"true" yaml:"status_address"`                                  // listen address for health checks and metrics, empty disables
+	Store               string            `yaml:"store" default:"mongo"`                                              // storage backend: mongo, file or memory
+	StorePath           string            `split_words:"true" yaml:"store_path"`                                      // path of the JSON file used by the file store, the audit log is appended to <path>.audit
 }
 
 func main() {
-	var config Config
-	err := envconfig.Process("maccer", &config)
+	config, err := LoadConfig()
 	if err != nil {
 		logger.Fatal("failed to load config",
 			zap.Error(err))
 	}
+
+	UseSecrets(config)
+
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(Check(config))
+	}
+
+	if !ReportConfig(config) {
+		logger.Fatal("config is invalid, see the problems above")
+	}
+
 	Start(config)
 }
 
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics exposed on /metrics in the Prometheus text format
+var (
+	commandsProcessed = NewMetric("maccer_commands_total",
+		"Commands processed by command and outcome.", "command", "outcome")
+	verificationEvents = NewMetric("maccer_verifications_total",
+		"Verifications by event: started, completed, refused, expired or failed.", "event")
+	forumDuration = NewHistogram("maccer_forum_request_duration_seconds",
+		"Latency of forum API requests.", []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 20}, "call")
+	forumErrors = NewMetric("maccer_forum_errors_total",
+		"Failed forum API requests, including error responses.", "call")
+	discordErrors = NewMetric("maccer_discord_errors_total",
+		"Failed Discord REST requests by status code, 0 when no response was received.", "status")
+)
+
+var allMetrics = []collector{commandsProcessed, verificationEvents, forumDuration, forumErrors, discordErrors}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Metric is a counter with a set of labels
+type Metric struct {
+	name   string
+	help   string
+	labels []string
+
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+// NewMetric creates a counter, each Inc must pass one value per label
+func NewMetric(name, help string, labels ...string) *Metric {
+	return &Metric{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+// Inc adds one to the counter for the given label values
+func (m *Metric) Inc(values ...string) {
+	m.lock.Lock()
+	m.values[labelSet(m.labels, values)]++
+	m.lock.Unlock()
+}
+
+func (m *Metric) write(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+	for _, labels := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, labels, formatFloat(m.values[labels]))
+	}
+}
+
+// Histogram counts observations into buckets, with a set of labels
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the given upper bounds, in ascending order
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+// Observe records a value for the given label values
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := labelSet(h.labels, values)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+// Since observes the seconds elapsed since start
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+		labels := append(append([]string{}, h.labels...), "le")
+		bucket := func(le string) string {
+			return labelSet(labels, append(append([]string{}, series.values...), le))
+		}
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, bucket(formatFloat(bound)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, bucket("+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, series.count)
+	}
+}
+
+// WriteMetrics writes every metric in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	for _, metric := range allMetrics {
+		metric.write(w)
+	}
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteMetrics(w)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelSet formats label names and values as `{name="value",...}`
+func labelSet(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + labelEscaper.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(values map[string]float64) (keys []string) {
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/patrickmn/go-cache"
+	"go.uber.org/zap"
+)
+
+// CheckResult is the outcome of one preflight check, the bot can't work at all
+// if a critical check fails
+type CheckResult struct {
+	Name     string
+	Passed   bool
+	Critical bool
+	Detail   string
+}
+
+// Preflight checks the guild, channels, roles and bot permissions the config
+// refers to, and that the forum API key can do what the bot needs
+func (app *App) Preflight(ctx context.Context) (results []CheckResult) {
+	config := app.Config()
+	s := app.discordClient
+
+	pass := func(critical bool, name, detail string, args ...interface{}) {
+		results = append(results, CheckResult{name, true, critical, fmt.Sprintf(detail, args...)})
+	}
+	fail := func(critical bool, name, detail string, args ...interface{}) {
+		results = append(results, CheckResult{name, false, critical, fmt.Sprintf(detail, args...)})
+	}
+
+	guild, err := s.Guild(config.GuildID)
+	if err != nil {
+		fail(true, "guild", "failed to get guild %s, is the bot a member? %v", config.GuildID, err)
+		return append(results, app.forumPreflight(ctx)...)
+	}
+	bot, err := s.GuildMember(config.GuildID, config.BotID)
+	if err != nil {
+		fail(true, "guild", "bot %s is not a member of %s: %v", config.BotID, guild.Name, err)
+		return append(results, app.forumPreflight(ctx)...)
+	}
+	pass(true, "guild", "member of %s", guild.Name)
+
+	channels := []struct {
+		name, id string
+		need     int
+	}{
+		{"administrative channel", config.AdministrativeChannel, discordgo.PermissionReadMessages | discordgo.PermissionSendMessages},
+		{"primary channel", config.PrimaryChannel, discordgo.PermissionReadMessages | discordgo.PermissionSendMessages | discordgo.PermissionEmbedLinks},
+		{"log channel", config.LogChannel, discordgo.PermissionSendMessages | discordgo.PermissionEmbedLinks},
+	}
+	for _, check := range channels {
+		channel, err := s.Channel(check.id)
+		if err != nil {
+			fail(false, check.name, "failed to get channel %s: %v", check.id, err)
+			continue
+		}
+		if channel.GuildID != config.GuildID {
+			fail(false, check.name, "#%s is not in %s", channel.Name, guild.Name)
+			continue
+		}
+		if channel.Type != discordgo.ChannelTypeGuildText {
+			fail(false, check.name, "#%s is not a text channel", channel.Name)
+			continue
+		}
+
+		permissions, err := s.UserChannelPermissions(config.BotID, channel.ID)
+		if err != nil {
+			fail(false, check.name, "failed to get permissions in #%s: %v", channel.Name, err)
+			continue
+		}
+		if missing := missingPermissions(permissions, check.need); missing != "" {
+			fail(false, check.name, "missing %s in #%s", missing, channel.Name)
+			continue
+		}
+		pass(false, check.name, "#%s", channel.Name)
+	}
+
+	results = append(results, rolePreflight(guild, bot, config)...)
+	results = append(results, app.forumPreflight(ctx)...)
+	return
+}
+
+// rolePreflight checks the bot can manage every role it assigns, which needs the
+// Manage Roles permission and a role higher than each of them
+func rolePreflight(guild *discordgo.Guild, bot *discordgo.Member, config Config) (results []CheckResult) {
+	roles := make(map[string]*discordgo.Role)
+	for _, role := range guild.Roles {
+		roles[role.ID] = role
+	}
+
+	permissions := 0
+	highest := 0
+	if everyone, ok := roles[guild.ID]; ok {
+		permissions = everyone.Permissions
+	}
+	for _, id := range bot.Roles {
+		if role, ok := roles[id]; ok {
+			permissions |= role.Permissions
+			if role.Position > highest {
+				highest = role.Position
+			}
+		}
+	}
+
+	if missing := missingPermissions(permissions, discordgo.PermissionManageRoles); missing != "" {
+		return []CheckResult{{"roles", false, true, "missing " + missing + " in " + guild.Name}}
+	}
+
+	type managedRole struct{ name, id string }
+	managed := []managedRole{{"verified role", config.VerifiedRole}}
+	for _, group := range sortedGroups(config.GroupRoles) {
+		managed = append(managed, managedRole{"role for forum group " + group, config.GroupRoles[group]})
+	}
+
+	for _, check := range managed {
+		role, ok := roles[check.id]
+		critical := check.id == config.VerifiedRole
+		switch {
+		case !ok:
+			results = append(results, CheckResult{check.name, false, critical, fmt.Sprintf("role %s does not exist", check.id)})
+		case role.Position >= highest:
+			results = append(results, CheckResult{check.name, false, critical, fmt.Sprintf("@%s is not below the bot's highest role", role.Name)})
+		default:
+			results = append(results, CheckResult{check.name, true, critical, "@" + role.Name})
+		}
+	}
+	return
+}
+
+// missingPermissions lists the permissions in need that are not in have
+func missingPermissions(have, need int) string {
+	if have&discordgo.PermissionAdministrator != 0 {
+		return ""
+	}
+
+	var missing []string
+	for _, permission := range []struct {
+		bit  int
+		name string
+	}{
+		{discordgo.PermissionReadMessages, "Read Messages"},
+		{discordgo.PermissionSendMessages, "Send Messages"},
+		{discordgo.PermissionEmbedLinks, "Embed Links"},
+		{discordgo.PermissionManageRoles, "Manage Roles"},
+	} {
+		if need&permission.bit != 0 && have&permission.bit == 0 {
+			missing = append(missing, permission.name)
+		}
+	}
+	return strings.Join(missing, ", ")
+}
+
+func sortedGroups(groupRoles map[string]string) (groups []string) {
+	for group := range groupRoles {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return
+}
+
+// forumPreflight checks the forum API is reachable and that the API key may
+// list and read members, which is all the bot uses it for
+func (app *App) forumPreflight(ctx context.Context) (results []CheckResult) {
+	err := app.PingForum(ctx)
+	if err != nil {
+		return []CheckResult{{"forum API", false, false, err.Error()}}
+	}
+	results = append(results, CheckResult{"forum API", true, false, app.Config().ForumEndpoint})
+
+	var list struct {
+		Results []struct {
+			ID int `json:"id"`
+		} `json:"results"`
+	}
+	err = app.forumGet(ctx, "preflight", "/api/core/members", url.Values{"perPage": {"1"}}, &list)
+	if err != nil {
+		return append(results, CheckResult{"forum key scopes", false, false, "GET /core/members: " + err.Error()})
+	}
+	if len(list.Results) > 0 {
+		err = app.forumGet(ctx, "preflight", fmt.Sprintf("/api/core/members/%d", list.Results[0].ID), nil, nil)
+		if err != nil {
+			return append(results, CheckResult{"forum key scopes", false, false, "GET /core/members/{id}: " + err.Error()})
+		}
+	}
+	return append(results, CheckResult{"forum key scopes", true, false, "can list and read members"})
+}
+
+// ReportPreflight logs the results of the preflight checks and posts them to
+// the log channel, returning false if a critical check failed
+func (app *App) ReportPreflight(results []CheckResult) (ok bool) {
+	ok = true
+	postable := true
+	lines := make([]string, len(results))
+
+	for i, result := range results {
+		if result.Passed {
+			logger.Info("preflight check passed",
+				zap.String("check", result.Name),
+				zap.String("detail", result.Detail))
+			lines[i] = fmt.Sprintf(":white_check_mark: **%s**: %s", result.Name, result.Detail)
+			continue
+		}
+
+		logger.Error("preflight check failed",
+			zap.String("check", result.Name),
+			zap.Bool("critical", result.Critical),
+			zap.String("detail", result.Detail))
+		lines[i] = fmt.Sprintf(":x: **%s**: %s", result.Name, result.Detail)
+
+		if result.Critical {
+			ok = false
+		}
+		if result.Name == "guild" || result.Name == "log channel" {
+			postable = false
+		}
+	}
+
+	if !postable {
+		return
+	}
+
+	title := "Preflight checks passed"
+	color := 0x2ecc71
+	for _, result := range results {
+		if !result.Passed {
+			title = "Preflight checks failed"
+			color = 0xe74c3c
+			break
+		}
+	}
+
+	_, err := app.discordClient.ChannelMessageSendEmbed(app.Config().LogChannel, &discordgo.MessageEmbed{
+		Title:       title,
+		Description: truncate(redact(strings.Join(lines, "\n")), 2048),
+		Color:       color,
+	})
+	if err != nil {
+		logger.Warn("failed to post preflight results", zap.Error(err))
+	}
+	return
+}
+
+// Check runs the config validation and preflight checks without starting the
+// bot, printing each result, and returns the process exit code
+func Check(config Config) int {
+	fmt.Println("Checking config...")
+	problems := config.Validate()
+	for _, problem := range problems {
+		fmt.Printf("FAIL  %s (MACCER_%s): %s\n", problem.Setting, strings.ToUpper(problem.Setting), problem.Problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Println("PASS  config")
+
+	app := &App{
+		liveConfig: &atomic.Value{},
+		cache:      cache.New(5*time.Minute, 30*time.Second),
+	}
+	app.liveConfig.Store(config)
+
+	var err error
+	app.discordClient, err = discordgo.New("Bot " + config.DiscordToken)
+	if err != nil {
+		fmt.Printf("FAIL  discord: %v\n", redact(err.Error()))
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	fmt.Println("Checking Discord, forum and store...")
+	results := app.Preflight(ctx)
+
+	store, err := NewStore(config)
+	if err == nil {
+		err = store.Ping()
+		store.Close()
+	}
+	if err != nil {
+		results = append(results, CheckResult{"store", false, true, err.Error()})
+	} else {
+		results = append(results, CheckResult{"store", true, true, config.Store})
+	}
+
+	code := 0
+	for _, result := range results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+			code = 1
+		}
+		fmt.Printf("%s  %s: %s\n", status, result.Name, redact(result.Detail))
+	}
+	return code
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Southclaws/invision-community-go"
+	"github.com/Southclaws/maccer/types"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ReconcileSummary describes the outcome of a single reconciliation pass
+type ReconcileSummary struct {
+	Checked  int
+	Missing  int
+	Revoked  int
+	Restored int
+	Held     int
+	Errors   int
+	Changes  []string
+}
+
+// StartReconciler periodically re-checks every linked account against the forum
+// and blocks until ctx is done, it does nothing if ReconcileInterval is zero
+func (app *App) StartReconciler(ctx context.Context) {
+	if app.Config().ReconcileInterval <= 0 {
+		logger.Debug("reconciler disabled")
+		return
+	}
+
+	ticker := time.NewTicker(app.Config().ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		summary, err := app.Reconcile(ctx)
+		if err != nil {
+			app.ChannelLogError(err)
+			continue
+		}
+
+		logger.Debug("reconciliation finished",
+			zap.Any("summary", summary))
+
+		if len(summary.Changes) > 0 || summary.Errors > 0 || summary.Held > 0 {
+			_, err = app.discordClient.ChannelMessageSend(app.Config().LogChannel, summary.String())
+			if err != nil {
+				app.ChannelLogError(err)
+			}
+		}
+	}
+}
+
+// Reconcile walks every linked user, re-fetches their forum account and revokes
+// or restores their Discord roles so they reflect the current forum state
+func (app *App) Reconcile(ctx context.Context) (summary ReconcileSummary, err error) {
+	users, err := app.GetUsers()
+	if err != nil {
+		return
+	}
+
+	for _, user := range users {
+		select {
+		case <-time.After(app.Config().ReconcileRate):
+		case <-ctx.Done():
+			return summary, ctx.Err()
+		}
+
+		change, inlineErr := app.reconcileUser(ctx, user, &summary)
+		if inlineErr != nil {
+			logger.Warn("failed to reconcile user",
+				zap.Error(inlineErr),
+				zap.String("discordID", user.DiscordID),
+				zap.String("forumID", user.ForumID))
+			summary.Errors++
+			continue
+		}
+		if change != "" {
+			summary.Changes = append(summary.Changes, change)
+		}
+	}
+
+	return
+}
+
+func (app *App) reconcileUser(ctx context.Context, user types.User, summary *ReconcileSummary) (change string, err error) {
+	summary.Checked++
+
+	member, err := app.FetchForumMember(ctx, user.ForumID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get member data from forum API")
+		return
+	}
+
+	guildMember, err := app.discordClient.GuildMember(app.Config().GuildID, user.DiscordID)
+	if err != nil {
+		if isNotFound(err) {
+			summary.Missing++
+			err = nil
+			return
+		}
+		err = errors.Wrap(err, "failed to get guild member")
+		return
+	}
+
+	valid, reason := app.MemberValid(member)
+
+	var want map[string]bool
+	if valid {
+		want = app.GroupRoles(member)
+		want[app.Config().VerifiedRole] = true
+	} else {
+		verified := false
+		for _, role := range guildMember.Roles {
+			if role == app.Config().VerifiedRole {
+				verified = true
+			}
+		}
+		if verified && summary.Revoked >= app.Config().ReconcileMaxRevoke {
+			summary.Held++
+			change = fmt.Sprintf("<@%s> (forum %s): held back revocation, %s", user.DiscordID, user.ForumID, reason)
+			return
+		}
+	}
+
+	changes, err := app.ApplyRoles(guildMember, append(app.MappedRoles(), app.Config().VerifiedRole), want)
+	if err != nil {
+		return
+	}
+	if changes.Empty() {
+		return
+	}
+
+	for _, role := range changes.Removed {
+		if role == app.Config().VerifiedRole {
+			summary.Revoked++
+		}
+	}
+	for _, role := range changes.Added {
+		if role == app.Config().VerifiedRole {
+			summary.Restored++
+		}
+	}
+
+	change = fmt.Sprintf("<@%s> (forum %s):%s%s",
+		user.DiscordID, user.ForumID, formatRoles(" +", changes.Added), formatRoles(" -", changes.Removed))
+	if !valid {
+		change += ", " + reason
+	}
+
+	return
+}
+
+// MemberValid returns whether a forum account is in good standing and, if not,
+// the reason why it isn't. A zero member is one the forum says does not exist.
+func (app *App) MemberValid(member ips.Member) (valid bool, reason string) {
+	if member.ID == 0 {
+		return false, "forum account no longer exists"
+	}
+	if member.Validating {
+		return false, "forum account is validating"
+	}
+	if app.Config().BannedGroup != "" && fmt.Sprint(member.PrimaryGroup.ID) == app.Config().BannedGroup {
+		return false, "forum account is banned"
+	}
+	return true, ""
+}
+
+func formatRoles(prefix string, roles []string) string {
+	buf := bytes.Buffer{}
+	for _, role := range roles {
+		buf.WriteString(fmt.Sprintf("%s<@&%s>", prefix, role))
+	}
+	return buf.String()
+}
+
+func (summary ReconcileSummary) String() string {
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf(
+		"**Reconciliation:** checked %d, not in guild %d, revoked %d, restored %d, held back %d, errors %d\n",
+		summary.Checked, summary.Missing, summary.Revoked, summary.Restored, summary.Held, summary.Errors))
+
+	for i, change := range summary.Changes {
+		line := change + "\n"
+		if buf.Len()+len(line) > 1900 {
+			buf.WriteString(fmt.Sprintf("...and %d more", len(summary.Changes)-i))
+			break
+		}
+		buf.WriteString(line)
+	}
+
+	return strings.TrimSpace(buf.String())
+}
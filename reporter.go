@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	reportQueue    = 128              // errors waiting to be grouped before new ones are only logged
+	reportInterval = 10 * time.Second // how often grouped errors are posted
+	reportRepeat   = 10 * time.Minute // how long before the same error is posted again
+	reportBurst    = 5                // most errors posted at each interval
+	reportBackoff  = time.Minute      // how long to only log after failing to post
+)
+
+// CommandError adds the command that was running to an error, so the error
+// reporter can say where it came from
+type CommandError struct {
+	Command   string
+	UserID    string
+	ChannelID string
+	Err       error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error, for errors.Cause
+func (e *CommandError) Cause() error {
+	return e.Err
+}
+
+// ErrorReporter posts errors to the log channel. Identical errors are grouped
+// and counted, the number of posts is limited and if Discord can't be reached
+// errors are only logged until it can be again.
+type ErrorReporter struct {
+	app   *App
+	queue chan reportedError
+
+	lock    sync.Mutex
+	pending map[string]*errorGroup
+	posted  map[string]time.Time
+	backoff time.Time
+}
+
+type reportedError struct {
+	err  error
+	time time.Time
+}
+
+type errorGroup struct {
+	message string
+	command *CommandError
+	users   map[string]bool
+	count   int
+	first   time.Time
+	last    time.Time
+}
+
+// NewErrorReporter creates an error reporter, errors are queued until Run starts
+func NewErrorReporter(app *App) *ErrorReporter {
+	return &ErrorReporter{
+		app:     app,
+		queue:   make(chan reportedError, reportQueue),
+		pending: make(map[string]*errorGroup),
+		posted:  make(map[string]time.Time),
+	}
+}
+
+// Report logs an error and queues it to be posted to the log channel, it never
+// blocks
+func (r *ErrorReporter) Report(err error) {
+	fields := []zapcore.Field{zap.Error(err)}
+	if command := commandOf(err); command != nil {
+		fields = append(fields,
+			zap.String("command", command.Command),
+			zap.String("userID", command.UserID),
+			zap.String("channelID", command.ChannelID))
+	}
+	logger.Error("error received", fields...)
+
+	select {
+	case r.queue <- reportedError{err, time.Now()}:
+	default:
+		logger.Warn("error report queue full, error was only logged")
+	}
+}
+
+// Run groups and posts queued errors until ctx is done, then posts whatever is
+// still pending
+func (r *ErrorReporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case reported := <-r.queue:
+			r.add(reported)
+
+		case <-ticker.C:
+			r.flush(false)
+
+		case <-ctx.Done():
+			r.drain()
+			r.flush(true)
+			return
+		}
+	}
+}
+
+func (r *ErrorReporter) drain() {
+	for {
+		select {
+		case reported := <-r.queue:
+			r.add(reported)
+		default:
+			return
+		}
+	}
+}
+
+func (r *ErrorReporter) add(reported reportedError) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	command := commandOf(reported.err)
+	message := redact(reported.err.Error())
+	key := message
+	if command != nil {
+		key = command.Command + "\x00" + message
+	}
+
+	group, ok := r.pending[key]
+	if !ok {
+		group = &errorGroup{
+			message: message,
+			command: command,
+			users:   make(map[string]bool),
+			first:   reported.time,
+		}
+		r.pending[key] = group
+	}
+	if command != nil {
+		group.users[command.UserID] = true
+	}
+	group.count++
+	group.last = reported.time
+}
+
+// flush posts the pending groups that have not been posted recently, oldest
+// first and at most reportBurst of them, unless final is set in which case
+// everything pending is posted
+func (r *ErrorReporter) flush(final bool) {
+	r.lock.Lock()
+	now := time.Now()
+
+	var keys []string
+	for key := range r.pending {
+		if final || now.Sub(r.posted[key]) >= reportRepeat {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return r.pending[keys[i]].first.Before(r.pending[keys[j]].first)
+	})
+	if !final && len(keys) > reportBurst {
+		keys = keys[:reportBurst]
+	}
+
+	groups := make([]*errorGroup, len(keys))
+	for i, key := range keys {
+		groups[i] = r.pending[key]
+		delete(r.pending, key)
+		r.posted[key] = now
+	}
+	for key, posted := range r.posted {
+		if now.Sub(posted) >= reportRepeat {
+			if _, ok := r.pending[key]; !ok {
+				delete(r.posted, key)
+			}
+		}
+	}
+	backoff := now.Before(r.backoff)
+	r.lock.Unlock()
+
+	for _, group := range groups {
+		if backoff {
+			logger.Debug("not posting error report, Discord was unavailable",
+				zap.String("error", group.message),
+				zap.Int("count", group.count))
+			continue
+		}
+
+		err := r.post(group)
+		if err != nil {
+			logger.Warn("failed to post error report, only logging errors for a while",
+				zap.Error(err),
+				zap.Duration("backoff", reportBackoff))
+
+			r.lock.Lock()
+			r.backoff = time.Now().Add(reportBackoff)
+			r.lock.Unlock()
+			backoff = true
+		}
+	}
+}
+
+func (r *ErrorReporter) post(group *errorGroup) (err error) {
+	session := r.app.discordClient
+	if session == nil {
+		return errors.New("not connected to Discord")
+	}
+
+	_, err = session.ChannelMessageSendEmbed(r.app.Config().LogChannel, group.embed())
+	return
+}
+
+// embed renders an error group for the log channel
+func (group *errorGroup) embed() *discordgo.MessageEmbed {
+	title := "Error"
+	if group.count > 1 {
+		title = fmt.Sprintf("Error (%d times)", group.count)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       title,
+		Description: "```\n" + truncate(group.message, 2000) + "\n```",
+		Color:       0xe74c3c,
+		Timestamp:   group.last.Format(time.RFC3339),
+	}
+
+	if group.command != nil {
+		users := ""
+		for user := range group.users {
+			if len(users) > 900 {
+				users += " ..."
+				break
+			}
+			users += fmt.Sprintf("<@%s> ", user)
+		}
+
+		embed.Fields = append(embed.Fields,
+			&discordgo.MessageEmbedField{Name: "Command", Value: group.command.Command, Inline: true},
+			&discordgo.MessageEmbedField{Name: "Channel", Value: fmt.Sprintf("<#%s>", group.command.ChannelID), Inline: true},
+			&discordgo.MessageEmbedField{Name: "Users", Value: users})
+	}
+
+	if group.count > 1 {
+		embed.Fields = append(embed.Fields,
+			&discordgo.MessageEmbedField{Name: "First seen", Value: group.first.UTC().Format(time.RFC1123), Inline: true},
+			&discordgo.MessageEmbedField{Name: "Last seen", Value: group.last.UTC().Format(time.RFC1123), Inline: true})
+	}
+
+	return embed
+}
+
+// commandOf returns the command an error came from, if it was wrapped in a
+// CommandError anywhere along its chain of causes
+func commandOf(err error) *CommandError {
+	for err != nil {
+		if command, ok := err.(*CommandError); ok {
+			return command
+		}
+		causer, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			return nil
+		}
+		err = causer.Cause()
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Southclaws/invision-community-go"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// RoleChanges describes the roles that were added to and removed from a member
+// during a role sync
+type RoleChanges struct {
+	Added   []string
+	Removed []string
+}
+
+// Empty returns true if the sync did not change any roles
+func (rc RoleChanges) Empty() bool {
+	return len(rc.Added) == 0 && len(rc.Removed) == 0
+}
+
+// GroupRoles returns the set of Discord role IDs that the member's forum groups
+// map to via the GroupRoles config
+func (app *App) GroupRoles(member ips.Member) (roles map[string]bool) {
+	roles = make(map[string]bool)
+
+	groups := append([]ips.Group{member.PrimaryGroup}, member.SecondaryGroups...)
+	for _, group := range groups {
+		role, ok := app.Config().GroupRoles[fmt.Sprint(group.ID)]
+		if ok {
+			roles[role] = true
+		}
+	}
+	return
+}
+
+// MappedRoles returns every Discord role ID that appears in the GroupRoles
+// config, these are the roles the bot considers itself responsible for
+func (app *App) MappedRoles() (roles []string) {
+	seen := make(map[string]bool)
+	for _, role := range app.Config().GroupRoles {
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	return
+}
+
+// SyncMemberRoles adds and removes mapped roles on a Discord member so they
+// match the groups of their forum account. Roles that are not part of the
+// GroupRoles mapping are never touched.
+func (app *App) SyncMemberRoles(discordID string, member ips.Member) (changes RoleChanges, err error) {
+	if len(app.Config().GroupRoles) == 0 {
+		return
+	}
+
+	guildMember, err := app.discordClient.GuildMember(app.Config().GuildID, discordID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get guild member")
+		return
+	}
+
+	return app.ApplyRoles(guildMember, app.MappedRoles(), app.GroupRoles(member))
+}
+
+// GrantRoles gives a Discord member the verified role and the mapped roles for
+// their forum account, or revokes them if the forum account is not valid
+func (app *App) GrantRoles(guildMember *discordgo.Member, member ips.Member) (changes RoleChanges, err error) {
+	var want map[string]bool
+	if valid, _ := app.MemberValid(member); valid {
+		want = app.GroupRoles(member)
+		want[app.Config().VerifiedRole] = true
+	}
+	return app.ApplyRoles(guildMember, append(app.MappedRoles(), app.Config().VerifiedRole), want)
+}
+
+// RevokeRoles removes the verified role and every mapped role from a Discord
+// user, doing nothing if they are not in the guild
+func (app *App) RevokeRoles(discordID string) (err error) {
+	guildMember, err := app.discordClient.GuildMember(app.Config().GuildID, discordID)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to get guild member")
+	}
+
+	_, err = app.ApplyRoles(guildMember, append(app.MappedRoles(), app.Config().VerifiedRole), nil)
+	return
+}
+
+// ApplyRoles adds each role in `managed` that is in `want` and removes each role
+// in `managed` that is not in `want`. Roles outside of `managed` are untouched.
+func (app *App) ApplyRoles(guildMember *discordgo.Member, managed []string, want map[string]bool) (changes RoleChanges, err error) {
+	has := make(map[string]bool)
+	for _, role := range guildMember.Roles {
+		has[role] = true
+	}
+
+	for _, role := range managed {
+		if want[role] && !has[role] {
+			err = app.discordClient.GuildMemberRoleAdd(app.Config().GuildID, guildMember.User.ID, role)
+			if err != nil {
+				err = errors.Wrap(err, "failed to add role")
+				return
+			}
+			changes.Added = append(changes.Added, role)
+		} else if !want[role] && has[role] {
+			err = app.discordClient.GuildMemberRoleRemove(app.Config().GuildID, guildMember.User.ID, role)
+			if err != nil {
+				err = errors.Wrap(err, "failed to remove role")
+				return
+			}
+			changes.Removed = append(changes.Removed, role)
+		}
+	}
+
+	if !changes.Empty() {
+		logger.Debug("applied member roles",
+			zap.String("userID", guildMember.User.ID),
+			zap.Strings("added", changes.Added),
+			zap.Strings("removed", changes.Removed))
+	}
+
+	return
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces secret values in logs and error reports
+const Redacted = "[redacted]"
+
+// redactor replaces every secret value with Redacted, it is set up by
+// UseSecrets once the config is loaded
+var redactor = strings.NewReplacer()
+
+// loadSecretFiles reads each secret setting from the file named by its
+// MACCER_*_FILE variable, for use with Docker secrets
+func loadSecretFiles(config *Config) (err error) {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("secret") != "true" {
+			continue
+		}
+
+		name := "MACCER_" + strings.ToUpper(yamlName(field))
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			return errors.Errorf("only one of %s and %s_FILE may be set", name, name)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s_FILE", name)
+		}
+		value.Field(i).SetString(strings.TrimRight(string(data), "\r\n"))
+	}
+	return
+}
+
+// secretValues returns the values of every secret setting that is set
+func (config Config) secretValues() (secrets []string) {
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).Tag.Get("secret") == "true" && value.Field(i).String() != "" {
+			secrets = append(secrets, value.Field(i).String())
+		}
+	}
+	return
+}
+
+// UseSecrets redacts the config's secrets from everything logged from now on
+func UseSecrets(config Config) {
+	var pairs []string
+	for _, secret := range config.secretValues() {
+		pairs = append(pairs, secret, Redacted)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			pairs = append(pairs, escaped, Redacted)
+		}
+	}
+	redactor = strings.NewReplacer(pairs...)
+
+	logger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redactingCore{core}
+	}))
+}
+
+// redact removes any secret values from a string
+func redact(s string) string {
+	return redactor.Replace(s)
+}
+
+// MarshalLogObject logs every setting, with secrets replaced by Redacted
+func (config Config) MarshalLogObject(enc zapcore.ObjectEncoder) (err error) {
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := yamlName(field)
+		if name == "-" {
+			name = field.Name
+		}
+
+		if field.Tag.Get("secret") == "true" {
+			if value.Field(i).String() != "" {
+				enc.AddString(name, Redacted)
+			}
+			continue
+		}
+
+		if duration, ok := value.Field(i).Interface().(time.Duration); ok {
+			enc.AddString(name, duration.String())
+			continue
+		}
+
+		err = enc.AddReflected(name, value.Field(i).Interface())
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// redactingCore removes secret values from log messages, string fields and
+// errors before they are written
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{c.Core.With(redactFields(fields))}
+}
+
+// Check asks the wrapped core first so its sampling still applies
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Check(entry, nil) != nil {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = redact(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = redact(field.String)
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				field.Interface = redactedError{err}
+			}
+		}
+		redacted[i] = field
+	}
+	return redacted
+}
+
+// redactedError removes secret values from an error's message and from its
+// verbose form, which includes the stack trace
+type redactedError struct {
+	err error
+}
+
+func (e redactedError) Error() string {
+	return redact(e.err.Error())
+}
+
+func (e redactedError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprint(s, redact(fmt.Sprintf("%+v", e.err)))
+		return
+	}
+	fmt.Fprint(s, e.Error())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRedaction(t *testing.T) {
+	defer func(l *zap.Logger, r *strings.Replacer) { logger, redactor = l, r }(logger, redactor)
+
+	buf := &bytes.Buffer{}
+	logger = zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(buf),
+		zap.DebugLevel))
+
+	config := Config{
+		DiscordToken: "discord.token-value",
+		ForumKey:     "forum/key+with=escapes",
+		MongoPass:    "mongo pass&word",
+		GuildID:      "guild",
+	}
+	UseSecrets(config)
+
+	var secrets []string
+	for _, secret := range config.secretValues() {
+		secrets = append(secrets, secret, url.QueryEscape(secret))
+	}
+	if len(secrets) != 6 {
+		t.Fatalf("secretValues() found %d secrets, want 3", len(secrets)/2)
+	}
+
+	for _, secret := range secrets {
+		logger.Info("message containing "+secret,
+			zap.Any("config", config),
+			zap.String("url", "https://forum.example.com/api/core/hello?key="+secret),
+			zap.Error(errors.Wrap(fmt.Errorf("request with %s failed", secret), "failed to get member")))
+	}
+
+	output := buf.String()
+	for _, secret := range secrets {
+		if strings.Contains(output, secret) {
+			t.Errorf("log output contains %q:\n%s", secret, output)
+		}
+	}
+	if !strings.Contains(output, Redacted) || !strings.Contains(output, `"guild_id":"guild"`) {
+		t.Errorf("log output is missing redacted or ordinary values:\n%s", output)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maccer-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+
+	path := filepath.Join(dir, "forum_key")
+	err = ioutil.WriteFile(path, []byte("from file\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"file", map[string]string{"MACCER_FORUM_KEY_FILE": path}, "from file", false},
+		{"variable and file", map[string]string{"MACCER_FORUM_KEY": "from env", "MACCER_FORUM_KEY_FILE": path}, "", true},
+		{"missing file", map[string]string{"MACCER_FORUM_KEY_FILE": filepath.Join(dir, "missing")}, "", true},
+		{"neither", nil, "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				os.Setenv(name, value)  // nolint:errcheck
+				defer os.Unsetenv(name) // nolint:errcheck
+			}
+
+			var config Config
+			err := loadSecretFiles(&config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if config.ForumKey != tt.want {
+				t.Errorf("ForumKey = %q, want %q", config.ForumKey, tt.want)
+			}
+		})
+	}
+}
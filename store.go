@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/Southclaws/maccer/types"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUserDiscordDuplicate is triggered when a discord ID is attempted to be registered twice
+	ErrUserDiscordDuplicate = errors.New("discord ID already registered")
+	// ErrUserForumDuplicate is triggered when a forum ID is attempted to be registered twice
+	ErrUserForumDuplicate = errors.New("forum ID already registered")
+	// ErrUserLinkChanged is triggered when a link is replaced but it was modified in the meantime
+	ErrUserLinkChanged = errors.New("link changed since it was read")
+	// ErrUserNotFound is triggered when a user that does not exist is updated
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// UserStore stores the links between Discord and forum accounts, each Discord ID
+// and each forum ID may only be linked once
+type UserStore interface {
+	CreateUser(user types.User) error
+	GetUserByDiscord(id string) (user types.User, exists bool, err error)
+	GetUserByForum(id string) (user types.User, exists bool, err error)
+	GetUsers() (users []types.User, err error)
+	UpdateUser(user types.User) error
+	ReplaceUser(old, replacement types.User) error
+	DeleteUser(discordID string) error
+	DeleteUserByForum(forumID string) error
+}
+
+// Store is everything the app persists: account links, pending verifications
+// and the audit log
+type Store interface {
+	UserStore
+
+	CreateVerification(verification types.Verification) error
+	GetVerifications() (verifications []types.Verification, err error)
+	DeleteVerification(discordID string) error
+
+	CreateAuditEntry(entry types.AuditEntry) error
+
+	Ping() error
+	Close()
+}
+
+// NewStore opens the storage backend selected by the Store config field
+func NewStore(config Config) (Store, error) {
+	switch config.Store {
+	case "mongo", "":
+		return NewMongoStore(config)
+	case "file":
+		return NewFileStore(config.StorePath)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, errors.Errorf("unknown store '%s', must be mongo, file or memory", config.Store)
+	}
+}
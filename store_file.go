@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Southclaws/maccer/types"
+	"github.com/pkg/errors"
+)
+
+// FileStore keeps links and verifications in memory and writes them to a JSON
+// file after every change, which is plenty for the few thousand links a server
+// will have. The audit log only ever grows, so it is appended to a separate
+// file with one JSON entry per line instead of being rewritten with the rest.
+type FileStore struct {
+	path   string
+	memory *MemoryStore
+	lock   sync.Mutex // serialises changes so the files are written in order
+}
+
+type fileContents struct {
+	Users         []types.User         `json:"users"`
+	Verifications []types.Verification `json:"verifications"`
+	Audit         []types.AuditEntry   `json:"audit,omitempty"` // only read, from before the audit file
+}
+
+// NewFileStore loads a file store from path, the file is created on the first
+// change if it does not exist. The audit log is kept next to it in path.audit.
+func NewFileStore(path string) (db *FileStore, err error) {
+	if path == "" {
+		return nil, errors.New("StorePath must be set to use the file store")
+	}
+
+	db = &FileStore{
+		path:   path,
+		memory: NewMemoryStore(),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read store file")
+	}
+
+	var contents fileContents
+	err = json.Unmarshal(data, &contents)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode store file")
+	}
+
+	for _, user := range contents.Users {
+		err = db.memory.CreateUser(user)
+		if err != nil {
+			return nil, errors.Wrapf(err, "store file has a conflicting link for %s", user.DiscordID)
+		}
+	}
+	for _, verification := range contents.Verifications {
+		db.memory.verifications[verification.DiscordID] = verification
+	}
+
+	// move an audit log written by an older version out to the audit file, then
+	// rewrite the store file without it so it is only moved once
+	if len(contents.Audit) > 0 {
+		for _, entry := range contents.Audit {
+			err = db.appendAudit(entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = db.save(db.memory)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// auditPath is the path of the append-only audit log
+func (db *FileStore) auditPath() string {
+	return db.path + ".audit"
+}
+
+// appendAudit writes an audit entry as a single line at the end of the audit
+// file, creating it if needed
+func (db *FileStore) appendAudit(entry types.AuditEntry) (err error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode audit entry")
+	}
+
+	file, err := os.OpenFile(db.auditPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit file")
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write audit file")
+	}
+	return
+}
+
+// change applies a change to a copy of the in-memory store and writes the copy
+// out to the file, the change only takes effect once it has been written
+func (db *FileStore) change(fn func(next *MemoryStore) error) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	next := db.memory.clone()
+	err = fn(next)
+	if err != nil {
+		return
+	}
+
+	err = db.save(next)
+	if err != nil {
+		return
+	}
+
+	db.memory.replace(next)
+	return
+}
+
+// save writes to a temporary file and renames it over the store file so a crash
+// never leaves a partially written store behind
+func (db *FileStore) save(memory *MemoryStore) (err error) {
+	memory.lock.RLock()
+	contents := fileContents{}
+	for _, user := range memory.users {
+		contents.Users = append(contents.Users, user)
+	}
+	for _, verification := range memory.verifications {
+		contents.Verifications = append(contents.Verifications, verification)
+	}
+	data, err := json.MarshalIndent(contents, "", "\t")
+	memory.lock.RUnlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode store file")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(db.path), filepath.Base(db.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create store file")
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write store file")
+	}
+
+	err = os.Rename(tmp.Name(), db.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to replace store file")
+	}
+	return
+}
+
+// CreateUser inserts a new record for a user
+func (db *FileStore) CreateUser(user types.User) error {
+	return db.change(func(next *MemoryStore) error { return next.CreateUser(user) })
+}
+
+// GetUserByDiscord returns a user via their discord ID
+func (db *FileStore) GetUserByDiscord(id string) (types.User, bool, error) {
+	return db.memory.GetUserByDiscord(id)
+}
+
+// GetUserByForum returns a user via their forum ID
+func (db *FileStore) GetUserByForum(id string) (types.User, bool, error) {
+	return db.memory.GetUserByForum(id)
+}
+
+// GetUsers returns every linked user, ordered by Discord ID
+func (db *FileStore) GetUsers() ([]types.User, error) {
+	return db.memory.GetUsers()
+}
+
+// UpdateUser updates the details for a user
+func (db *FileStore) UpdateUser(user types.User) error {
+	return db.change(func(next *MemoryStore) error { return next.UpdateUser(user) })
+}
+
+// ReplaceUser atomically swaps an existing link for a new one, failing with
+// ErrUserLinkChanged if the existing link no longer matches `old`
+func (db *FileStore) ReplaceUser(old, replacement types.User) error {
+	return db.change(func(next *MemoryStore) error { return next.ReplaceUser(old, replacement) })
+}
+
+// DeleteUser removes the link for a Discord user
+func (db *FileStore) DeleteUser(discordID string) error {
+	return db.change(func(next *MemoryStore) error { return next.DeleteUser(discordID) })
+}
+
+// DeleteUserByForum removes the link for a forum user
+func (db *FileStore) DeleteUserByForum(forumID string) error {
+	return db.change(func(next *MemoryStore) error { return next.DeleteUserByForum(forumID) })
+}
+
+// CreateAuditEntry stores a record of an administrative action in the audit file
+func (db *FileStore) CreateAuditEntry(entry types.AuditEntry) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.appendAudit(entry)
+}
+
+// CreateVerification stores a pending verification, replacing any existing one
+// for the same Discord user
+func (db *FileStore) CreateVerification(verification types.Verification) error {
+	return db.change(func(next *MemoryStore) error { return next.CreateVerification(verification) })
+}
+
+// GetVerifications returns all pending verifications
+func (db *FileStore) GetVerifications() ([]types.Verification, error) {
+	return db.memory.GetVerifications()
+}
+
+// DeleteVerification removes a pending verification for a Discord user
+func (db *FileStore) DeleteVerification(discordID string) error {
+	return db.change(func(next *MemoryStore) error { return next.DeleteVerification(discordID) })
+}
+
+// Ping always succeeds, write failures are reported by the change that failed
+func (db *FileStore) Ping() error {
+	return nil
+}
+
+// Close does nothing, every change is written as soon as it is made
+func (db *FileStore) Close() {}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Southclaws/maccer/types"
+)
+
+// MemoryStore keeps everything in memory and loses it on exit, it is intended
+// for tests and trying the bot out
+type MemoryStore struct {
+	lock          sync.RWMutex
+	users         map[string]types.User // by Discord ID
+	forumUsers    map[string]string     // forum ID to Discord ID
+	verifications map[string]types.Verification
+	audit         []types.AuditEntry
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         make(map[string]types.User),
+		forumUsers:    make(map[string]string),
+		verifications: make(map[string]types.Verification),
+	}
+}
+
+// clone returns a copy of the store that can be changed independently
+func (db *MemoryStore) clone() *MemoryStore {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	clone := NewMemoryStore()
+	for id, user := range db.users {
+		clone.users[id] = user
+	}
+	for forumID, discordID := range db.forumUsers {
+		clone.forumUsers[forumID] = discordID
+	}
+	for id, verification := range db.verifications {
+		clone.verifications[id] = verification
+	}
+	clone.audit = append([]types.AuditEntry(nil), db.audit...)
+	return clone
+}
+
+// replace swaps the store's contents for those of another store, which must not
+// be used afterwards
+func (db *MemoryStore) replace(other *MemoryStore) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.users = other.users
+	db.forumUsers = other.forumUsers
+	db.verifications = other.verifications
+	db.audit = other.audit
+}
+
+// CreateUser inserts a new record for a user
+func (db *MemoryStore) CreateUser(user types.User) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.users[user.DiscordID]; ok {
+		return ErrUserDiscordDuplicate
+	}
+	if _, ok := db.forumUsers[user.ForumID]; ok {
+		return ErrUserForumDuplicate
+	}
+
+	db.users[user.DiscordID] = user
+	db.forumUsers[user.ForumID] = user.DiscordID
+	return
+}
+
+// GetUserByDiscord returns a user via their discord ID
+func (db *MemoryStore) GetUserByDiscord(id string) (user types.User, exists bool, err error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	user, exists = db.users[id]
+	return
+}
+
+// GetUserByForum returns a user via their forum ID
+func (db *MemoryStore) GetUserByForum(id string) (user types.User, exists bool, err error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	discordID, exists := db.forumUsers[id]
+	if exists {
+		user = db.users[discordID]
+	}
+	return
+}
+
+// GetUsers returns every linked user, ordered by Discord ID
+func (db *MemoryStore) GetUsers() (users []types.User, err error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	for _, user := range db.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].DiscordID < users[j].DiscordID
+	})
+	return
+}
+
+// UpdateUser updates the details for a user
+func (db *MemoryStore) UpdateUser(user types.User) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	existing, ok := db.users[user.DiscordID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if discordID, ok := db.forumUsers[user.ForumID]; ok && discordID != user.DiscordID {
+		return ErrUserForumDuplicate
+	}
+
+	delete(db.forumUsers, existing.ForumID)
+	db.users[user.DiscordID] = user
+	db.forumUsers[user.ForumID] = user.DiscordID
+	return
+}
+
+// ReplaceUser atomically swaps an existing link for a new one, failing with
+// ErrUserLinkChanged if the existing link no longer matches `old`
+func (db *MemoryStore) ReplaceUser(old, replacement types.User) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if existing, ok := db.users[old.DiscordID]; !ok || existing != old {
+		return ErrUserLinkChanged
+	}
+	if discordID, ok := db.forumUsers[replacement.ForumID]; ok && discordID != old.DiscordID {
+		return ErrUserForumDuplicate
+	}
+	if _, ok := db.users[replacement.DiscordID]; ok && replacement.DiscordID != old.DiscordID {
+		return ErrUserDiscordDuplicate
+	}
+
+	delete(db.users, old.DiscordID)
+	delete(db.forumUsers, old.ForumID)
+	db.users[replacement.DiscordID] = replacement
+	db.forumUsers[replacement.ForumID] = replacement.DiscordID
+	return
+}
+
+// DeleteUser removes the link for a Discord user
+func (db *MemoryStore) DeleteUser(discordID string) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if user, ok := db.users[discordID]; ok {
+		delete(db.users, discordID)
+		delete(db.forumUsers, user.ForumID)
+	}
+	return
+}
+
+// DeleteUserByForum removes the link for a forum user
+func (db *MemoryStore) DeleteUserByForum(forumID string) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if discordID, ok := db.forumUsers[forumID]; ok {
+		delete(db.users, discordID)
+		delete(db.forumUsers, forumID)
+	}
+	return
+}
+
+// CreateAuditEntry stores a record of an administrative action
+func (db *MemoryStore) CreateAuditEntry(entry types.AuditEntry) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.audit = append(db.audit, entry)
+	return
+}
+
+// CreateVerification stores a pending verification, replacing any existing one
+// for the same Discord user
+func (db *MemoryStore) CreateVerification(verification types.Verification) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.verifications[verification.DiscordID] = verification
+	return
+}
+
+// GetVerifications returns all pending verifications
+func (db *MemoryStore) GetVerifications() (verifications []types.Verification, err error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	for _, verification := range db.verifications {
+		verifications = append(verifications, verification)
+	}
+	return
+}
+
+// DeleteVerification removes a pending verification for a Discord user
+func (db *MemoryStore) DeleteVerification(discordID string) (err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.verifications, discordID)
+	return
+}
+
+// Ping always succeeds
+func (db *MemoryStore) Ping() error {
+	return nil
+}
+
+// Close does nothing
+func (db *MemoryStore) Close() {}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Southclaws/maccer/types"
+)
+
+// storeBackends returns a fresh instance of every backend that can run without
+// a server, each test runs against all of them
+func storeBackends(t *testing.T) (stores map[string]Store, cleanup func()) {
+	dir, err := ioutil.TempDir("", "maccer-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() { os.RemoveAll(dir) } // nolint:errcheck
+
+	file, err := NewFileStore(filepath.Join(dir, "store.json"))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   file,
+	}, cleanup
+}
+
+func TestStoreCreateUser(t *testing.T) {
+	stores, cleanup := storeBackends(t)
+	defer cleanup()
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			err := store.CreateUser(types.User{DiscordID: "1", ForumID: "10"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tt := range []struct {
+				user types.User
+				want error
+			}{
+				{types.User{DiscordID: "1", ForumID: "11"}, ErrUserDiscordDuplicate},
+				{types.User{DiscordID: "2", ForumID: "10"}, ErrUserForumDuplicate},
+				{types.User{DiscordID: "2", ForumID: "11"}, nil},
+			} {
+				if err := store.CreateUser(tt.user); err != tt.want {
+					t.Errorf("CreateUser(%v) = %v, want %v", tt.user, err, tt.want)
+				}
+			}
+
+			user, exists, err := store.GetUserByForum("11")
+			if err != nil || !exists || user.DiscordID != "2" {
+				t.Errorf("GetUserByForum(11) = %v, %v, %v", user, exists, err)
+			}
+		})
+	}
+}
+
+func TestStoreUpdateUser(t *testing.T) {
+	stores, cleanup := storeBackends(t)
+	defer cleanup()
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			mustCreateUsers(t, store, types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "2", ForumID: "20"})
+
+			for _, tt := range []struct {
+				user types.User
+				want error
+			}{
+				{types.User{DiscordID: "3", ForumID: "30"}, ErrUserNotFound},
+				{types.User{DiscordID: "1", ForumID: "20"}, ErrUserForumDuplicate},
+				{types.User{DiscordID: "1", ForumID: "11"}, nil},
+			} {
+				if err := store.UpdateUser(tt.user); err != tt.want {
+					t.Errorf("UpdateUser(%v) = %v, want %v", tt.user, err, tt.want)
+				}
+			}
+
+			if _, exists, _ := store.GetUserByForum("10"); exists {
+				t.Error("old forum ID is still linked after update")
+			}
+		})
+	}
+}
+
+func TestStoreReplaceUser(t *testing.T) {
+	stores, cleanup := storeBackends(t)
+	defer cleanup()
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			mustCreateUsers(t, store, types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "2", ForumID: "20"})
+
+			for _, tt := range []struct {
+				old, replacement types.User
+				want             error
+			}{
+				{types.User{DiscordID: "1", ForumID: "99"}, types.User{DiscordID: "1", ForumID: "11"}, ErrUserLinkChanged},
+				{types.User{DiscordID: "3", ForumID: "30"}, types.User{DiscordID: "3", ForumID: "31"}, ErrUserLinkChanged},
+				{types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "1", ForumID: "20"}, ErrUserForumDuplicate},
+				{types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "2", ForumID: "10"}, ErrUserDiscordDuplicate},
+				{types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "3", ForumID: "10"}, nil},
+				{types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "1", ForumID: "11"}, ErrUserLinkChanged},
+			} {
+				if err := store.ReplaceUser(tt.old, tt.replacement); err != tt.want {
+					t.Errorf("ReplaceUser(%v, %v) = %v, want %v", tt.old, tt.replacement, err, tt.want)
+				}
+			}
+
+			if _, exists, _ := store.GetUserByDiscord("1"); exists {
+				t.Error("replaced Discord ID is still linked")
+			}
+			user, exists, err := store.GetUserByForum("10")
+			if err != nil || !exists || user.DiscordID != "3" {
+				t.Errorf("GetUserByForum(10) = %v, %v, %v", user, exists, err)
+			}
+		})
+	}
+}
+
+func TestStoreDeleteUser(t *testing.T) {
+	stores, cleanup := storeBackends(t)
+	defer cleanup()
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			mustCreateUsers(t, store, types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "2", ForumID: "20"})
+
+			for _, err := range []error{
+				store.DeleteUser("1"),
+				store.DeleteUserByForum("20"),
+				store.DeleteUser("3"),
+				store.DeleteUserByForum("30"),
+			} {
+				if err != nil {
+					t.Errorf("delete failed: %v", err)
+				}
+			}
+
+			users, err := store.GetUsers()
+			if err != nil || len(users) != 0 {
+				t.Errorf("GetUsers() = %v, %v, want no users", users, err)
+			}
+
+			// both IDs are free to link again
+			mustCreateUsers(t, store, types.User{DiscordID: "1", ForumID: "20"})
+		})
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maccer-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+	path := filepath.Join(dir, "store.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(time.Minute).Round(time.Second)
+	mustCreateUsers(t, store, types.User{DiscordID: "1", ForumID: "10"}, types.User{DiscordID: "2", ForumID: "20"})
+	for _, err := range []error{
+		store.DeleteUser("2"),
+		store.CreateVerification(types.Verification{DiscordID: "3", ForumID: "30", Code: "code", Expires: expires}),
+		store.CreateAuditEntry(types.AuditEntry{AdminID: "4", Action: "forcelink", DiscordID: "1", ForumID: "10"}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := reopened.GetUsers()
+	if err != nil || len(users) != 1 || users[0] != (types.User{DiscordID: "1", ForumID: "10"}) {
+		t.Errorf("GetUsers() = %v, %v", users, err)
+	}
+	if _, exists, _ := reopened.GetUserByForum("20"); exists {
+		t.Error("deleted user was stored")
+	}
+
+	verifications, err := reopened.GetVerifications()
+	if err != nil || len(verifications) != 1 || verifications[0].Code != "code" || !verifications[0].Expires.Equal(expires) {
+		t.Errorf("GetVerifications() = %v, %v", verifications, err)
+	}
+
+	audit := readAudit(t, path+".audit")
+	if len(audit) != 1 || audit[0].AdminID != "4" {
+		t.Errorf("audit = %v", audit)
+	}
+}
+
+func TestFileStoreMovesLegacyAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maccer-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+	path := filepath.Join(dir, "store.json")
+
+	err = ioutil.WriteFile(path, []byte(`{"users":[{"discord_id":"1","forum_id":"10"}],"audit":[{"admin_id":"4"},{"admin_id":"5"}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// opening twice must not move the entries twice
+	for i := 0; i < 2; i++ {
+		_, err = NewFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	audit := readAudit(t, path+".audit")
+	if len(audit) != 2 || audit[0].AdminID != "4" || audit[1].AdminID != "5" {
+		t.Errorf("audit = %v", audit)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "audit") {
+		t.Errorf("store file still has the audit log: %s", data)
+	}
+}
+
+func readAudit(t *testing.T, path string) (entries []types.AuditEntry) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry types.AuditEntry
+		err = json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func TestFileStoreFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maccer-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+
+	store, err := NewFileStore(filepath.Join(dir, "missing", "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.CreateUser(types.User{DiscordID: "1", ForumID: "10"})
+	if err == nil {
+		t.Fatal("CreateUser succeeded without a directory to write to")
+	}
+
+	if _, exists, _ := store.GetUserByDiscord("1"); exists {
+		t.Error("link that failed to be written was kept")
+	}
+}
+
+func mustCreateUsers(t *testing.T, store Store, users ...types.User) {
+	t.Helper()
+	for _, user := range users {
+		err := store.CreateUser(user)
+		if err != nil {
+			t.Fatalf("CreateUser(%v) = %v", user, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package types
+
+import "time"
+
+// AuditEntry records an administrative action taken on a link
+type AuditEntry struct {
+	Time      time.Time `json:"time"       bson:"time"`       // when the action was taken
+	AdminID   string    `json:"admin_id"   bson:"admin_id"`   // discord user ID of the acting admin
+	Action    string    `json:"action"     bson:"action"`     // name of the action, such as "forcelink"
+	DiscordID string    `json:"discord_id" bson:"discord_id"` // discord user ID the action was taken on
+	ForumID   string    `json:"forum_id"   bson:"forum_id"`   // IPB forum user ID the action was taken on
+	Previous  []User    `json:"previous"   bson:"previous"`   // links that existed before the action
+}
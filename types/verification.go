@@ -0,0 +1,14 @@
+package types
+
+import "time"
+
+// Verification represents a pending verification session that is waiting for
+// the user to paste their code into their forum profile
+type Verification struct {
+	DiscordID string    `json:"discord_id" bson:"discord_id"` // discord user ID
+	ForumID   string    `json:"forum_id"   bson:"forum_id"`   // IPB forum user ID
+	Code      string    `json:"code"       bson:"code"`       // code the user must paste into their profile
+	ChannelID string    `json:"channel_id" bson:"channel_id"` // channel the verification was started in
+	Expires   time.Time `json:"expires"    bson:"expires"`    // time at which the verification is abandoned
+	Replaces  *User     `json:"replaces"   bson:"replaces"`   // existing link to replace on success, if relinking
+}